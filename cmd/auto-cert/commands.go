@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/maxroll/auto-cert/pkg/auth"
+	"github.com/maxroll/auto-cert/pkg/jobs"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/requestor"
+	"github.com/maxroll/auto-cert/pkg/status"
+	"github.com/maxroll/auto-cert/pkg/util"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"issue":    {"Request a new certificate with a fresh private key and run the runners", runIssue},
+		"renew":    {"Renew the certificate if needed and run the runners", runRenew},
+		"deploy":   {"Deploy the stored certificate to the runners without calling ACME", runDeploy},
+		"verify":   {"Check the deployed certificates for drift and redeploy", runVerify},
+		"status":   {"Show validity of the stored certificate", runStatus},
+		"inspect":  {"Show details of the stored certificate chain", runInspect},
+		"history":  {"List the stored versions of the secret", runHistory},
+		"rollback": {"Restore a previous secret version and deploy it to the runners", runRollback},
+		"runners":  {"List the available runners and their settings", runRunners},
+		"serve":    {"Start the HTTP listener, renewing when /cert is called", runServe},
+		"daemon":   {"Periodically renew the certificate and run the runners", runDaemon},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: auto-cert <command> [flags]\n\nCommands:\n")
+
+	for _, name := range []string{"issue", "renew", "deploy", "verify", "status", "inspect", "history", "rollback", "runners", "serve", "daemon"} {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].description)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nRun auto-cert <command> -h for the flags of a command\n")
+}
+
+// newRunContext returns a context whose logger tags every line with the run ID
+func newRunContext(runID string) context.Context {
+	return logging.WithRunID(context.Background(), runID)
+}
+
+// setup parses the flags of a command and loads the config
+func setup(ctx context.Context, name string, args []string, needsHostnames bool, needsRunners bool, extra func(fs *flag.FlagSet)) (*Config, error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	opts := registerFlags(fs)
+
+	if extra != nil {
+		extra(fs)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return newConfig(ctx, opts, needsHostnames, needsRunners)
+}
+
+func runIssue(args []string) error {
+	ctx := newRunContext(logging.NewRunID())
+
+	config, err := setup(ctx, "issue", args, true, true, nil)
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	result, err := withLock(ctx, config, func() (*Result, error) {
+		return issue(ctx, config)
+	})
+	observe(ctx, config, nil, result, err)
+
+	return err
+}
+
+func runRenew(args []string) error {
+	ctx := newRunContext(logging.NewRunID())
+
+	config, err := setup(ctx, "renew", args, true, true, nil)
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	result, err := withLock(ctx, config, func() (*Result, error) {
+		return execute(ctx, config)
+	})
+	observe(ctx, config, nil, result, err)
+
+	return err
+}
+
+func runDeploy(args []string) error {
+	ctx := newRunContext(logging.NewRunID())
+
+	config, err := setup(ctx, "deploy", args, true, true, nil)
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	result, err := withLock(ctx, config, func() (*Result, error) {
+		if config.secret == nil {
+			return nil, fmt.Errorf("secret does not exist, nothing to deploy")
+		}
+
+		certificate, _, err := storedCertificate(config.secret)
+		if err != nil {
+			return nil, err
+		}
+
+		return deploy(ctx, config, certificate)
+	})
+	observe(ctx, config, nil, result, err)
+
+	return err
+}
+
+func runStatus(args []string) error {
+	ctx := context.Background()
+
+	config, err := setup(ctx, "status", args, false, false, nil)
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	if config.secret == nil {
+		fmt.Println("No certificate stored")
+		return nil
+	}
+
+	certificate, cert, err := storedCertificate(config.secret)
+	if err != nil {
+		return err
+	}
+
+	hostnames := config.hostnames
+	if hostnames == nil {
+		hostnames = config.secret.Hostnames
+	}
+
+	fmt.Printf("Hostnames:      %s\n", strings.Join(config.secret.Hostnames, ", "))
+	fmt.Printf("SANs:           %s\n", strings.Join(cert.DNSNames, ", "))
+	fmt.Printf("Issuer:         %s\n", cert.Issuer.String())
+	fmt.Printf("Not before:     %s\n", cert.NotBefore)
+	fmt.Printf("Not after:      %s\n", cert.NotAfter)
+	fmt.Printf("Days remaining: %d\n", int(time.Until(cert.NotAfter).Hours())/24)
+
+	if err := requestor.ValidateCertificate(ctx, certificate, hostnames, config.validation); err != nil {
+		fmt.Printf("Valid:          no (%v)\n", err)
+	} else {
+		fmt.Printf("Valid:          yes\n")
+	}
+
+	return nil
+}
+
+func runInspect(args []string) error {
+	config, err := setup(context.Background(), "inspect", args, false, false, nil)
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	if config.secret == nil {
+		fmt.Println("No certificate stored")
+		return nil
+	}
+
+	certificates, err := certcrypto.ParsePEMBundle([]byte(config.secret.Certificate))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Stored hostnames: %s\n", strings.Join(config.secret.Hostnames, ", "))
+	fmt.Printf("Account email:    %s\n", config.secret.User.Email)
+
+	for i, cert := range certificates {
+		printCertificate(i, cert)
+	}
+
+	return nil
+}
+
+func printCertificate(index int, cert *x509.Certificate) {
+	fmt.Printf("\nCertificate #%d\n", index)
+	fmt.Printf("  Subject:     %s\n", cert.Subject.String())
+	fmt.Printf("  Issuer:      %s\n", cert.Issuer.String())
+	fmt.Printf("  Serial:      %s\n", cert.SerialNumber.Text(16))
+	fmt.Printf("  Not before:  %s\n", cert.NotBefore)
+	fmt.Printf("  Not after:   %s\n", cert.NotAfter)
+	fmt.Printf("  Key:         %s\n", cert.PublicKeyAlgorithm.String())
+	fmt.Printf("  SHA-256:     %s\n", util.Fingerprint(cert))
+
+	if len(cert.DNSNames) > 0 {
+		fmt.Printf("  SANs:        %s\n", strings.Join(cert.DNSNames, ", "))
+	}
+}
+
+func runServe(args []string) error {
+	var port int
+	var authMode string
+
+	ctx := context.Background()
+	log := logging.FromContext(ctx)
+
+	config, err := setup(ctx, "serve", args, true, true, func(fs *flag.FlagSet) {
+		fs.IntVar(&port, "port", env.GetOrDefaultInt("AUTOCERT_LISTENER_PORT", 8080), "Port to listen on (AUTOCERT_LISTENER_PORT)")
+		fs.StringVar(&authMode, "auth", env.GetOrDefaultString("AUTOCERT_AUTH_MODE", "none"), "Authentication for mutating endpoints: none, bearer, hmac or oidc (AUTOCERT_AUTH_MODE)")
+	})
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	authenticator, err := auth.NewAuthenticator(authMode)
+	if err != nil {
+		return err
+	}
+
+	if authenticator == nil {
+		log.Warnf("No authentication configured, anyone reaching the listener can trigger renewals")
+	}
+
+	log.Infof("Starting auto-cert in listener mode on port %d", port)
+
+	tracker := status.NewTracker()
+
+	if err := tracker.SetSecret(config.secretName, config.secret); err != nil {
+		log.Warnf("Could not read stored certificate: %s", err.Error())
+	}
+
+	recordExpiry(ctx, config)
+
+	queue := jobs.NewQueue(func(id string) (interface{}, error) {
+		// the job ID doubles as run ID
+		ctx := newRunContext(id)
+
+		result, err := withLock(ctx, config, func() (*Result, error) {
+			return execute(ctx, config)
+		})
+		observe(ctx, config, tracker, result, err)
+
+		return result, err
+	})
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "OK")
+	})
+	http.Handle("/cert", auth.Middleware(authenticator, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		job, created := queue.Enqueue()
+
+		if created {
+			log.With("run_id", job.ID).Infof("Started job %s", job.ID)
+		} else {
+			log.With("run_id", job.ID).Infof("Job %s already %s, not starting another one", job.ID, job.State)
+		}
+
+		writeJSON(w, http.StatusAccepted, job)
+	})))
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tracker.Summary(renewBefore))
+	})
+	http.HandleFunc("/certificates", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, tracker.Certificates())
+	})
+	http.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := queue.Get(strings.TrimPrefix(r.URL.Path, "/jobs/"))
+
+		if !ok {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, job)
+	})
+
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Default().Errorf("Could not write response: %s", err.Error())
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+	cloudflare "github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/maxroll/auto-cert/pkg/audit"
+	"github.com/maxroll/auto-cert/pkg/lock"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/notify"
+	"github.com/maxroll/auto-cert/pkg/requestor"
+	"github.com/maxroll/auto-cert/pkg/runner"
+	"github.com/maxroll/auto-cert/pkg/secrets"
+	"github.com/maxroll/auto-cert/pkg/tlscheck"
+	"github.com/maxroll/auto-cert/pkg/util"
+)
+
+type Config struct {
+	secretName     string
+	email          string
+	providerName   string
+	acmeURL        string
+	hostnames      []string
+	forceRenew     bool
+	forceRunners   bool
+	runnerManager  *runner.RunnerManager
+	secretBackend  secrets.SecretBackend
+	secret         *secrets.Secret
+	requestor      *requestor.Requestor
+	user           *requestor.AcmeUser
+	validation     requestor.ValidateOptions
+	reissueBackoff time.Duration
+	notifier       *notify.Dispatcher
+	audit          audit.Recorder
+	verify         bool
+	verifyTLS      bool
+	tlsCheck       *tlsCheckConfig
+	tlsOverrides   map[string]tlscheck.Target
+	locker         lock.Locker
+	lockTimeout    time.Duration
+	dryRun         bool
+}
+
+// tlsCheckConfig controls the check that hostnames serve the certificate
+// after it was deployed
+type tlsCheckConfig struct {
+	window   time.Duration
+	interval time.Duration
+}
+
+// options holds the command line flags, every flag defaults to its
+// AUTOCERT_* env var so flags override the environment
+type options struct {
+	secretBackend string
+	secretName    string
+	email         string
+	provider      string
+	acmeURL       string
+	caRoots       string
+	hostnames     string
+	runners       string
+	notifiers     string
+	notifyEvents  string
+	auditLog      string
+	lock          string
+	verify        bool
+	verifyTLS     bool
+	tlsCheck      bool
+	forceRenew    bool
+	forceRunners  bool
+	dryRun        bool
+}
+
+func registerFlags(fs *flag.FlagSet) *options {
+	opts := &options{}
+
+	fs.StringVar(&opts.secretBackend, "secret-backend", env.GetOrDefaultString("AUTOCERT_SECRET_BACKEND", "secretmanager"), "Secret backend storing the certificate (AUTOCERT_SECRET_BACKEND)")
+	fs.StringVar(&opts.secretName, "secret-name", env.GetOrDefaultString("AUTOCERT_SECRET_NAME", ""), "Name of the secret (AUTOCERT_SECRET_NAME)")
+	fs.StringVar(&opts.email, "email", env.GetOrDefaultString("AUTOCERT_EMAIL", ""), "ACME account email (AUTOCERT_EMAIL)")
+	fs.StringVar(&opts.provider, "provider", env.GetOrDefaultString("AUTOCERT_PROVIDER", "cloudflare"), "DNS provider used for challenges (AUTOCERT_PROVIDER)")
+	fs.StringVar(&opts.acmeURL, "acme-url", env.GetOrDefaultString("AUTOCERT_ACME_URL", "https://acme-staging-v02.api.letsencrypt.org/directory"), "ACME directory URL (AUTOCERT_ACME_URL)")
+	fs.StringVar(&opts.caRoots, "ca-roots", env.GetOrDefaultString("AUTOCERT_CA_ROOTS", ""), "PEM file with roots to verify chains against (AUTOCERT_CA_ROOTS)")
+	fs.StringVar(&opts.hostnames, "hostnames", env.GetOrDefaultString("AUTOCERT_HOSTNAMES", ""), "Comma separated hostnames (AUTOCERT_HOSTNAMES)")
+	fs.StringVar(&opts.runners, "runners", env.GetOrDefaultString("AUTOCERT_RUNNERS", ""), "Comma separated runners (AUTOCERT_RUNNERS)")
+	fs.StringVar(&opts.notifiers, "notifiers", env.GetOrDefaultString("AUTOCERT_NOTIFIERS", ""), "Comma separated notifiers: webhook, slack, smtp (AUTOCERT_NOTIFIERS)")
+	fs.StringVar(&opts.notifyEvents, "notify-events", env.GetOrDefaultString("AUTOCERT_NOTIFY_EVENTS", "issued,renewed,deployed,rolled_back,failed"), "Comma separated events to notify about (AUTOCERT_NOTIFY_EVENTS)")
+	fs.StringVar(&opts.auditLog, "audit-log", env.GetOrDefaultString("AUTOCERT_AUDIT_LOG", ""), "JSONL file recording the certificate lifecycle (AUTOCERT_AUDIT_LOG)")
+	fs.StringVar(&opts.lock, "lock", env.GetOrDefaultString("AUTOCERT_LOCK", "none"), "Lock held while renewing and deploying: none, file or secret (AUTOCERT_LOCK)")
+	fs.BoolVar(&opts.verify, "verify", env.GetOrDefaultBool("AUTOCERT_VERIFY", false), "Check deployments for drift when the certificate is not renewed and redeploy (AUTOCERT_VERIFY)")
+	fs.BoolVar(&opts.verifyTLS, "verify-tls", env.GetOrDefaultBool("AUTOCERT_VERIFY_TLS", true), "Include the certificate served for every hostname when checking for drift (AUTOCERT_VERIFY_TLS)")
+	fs.BoolVar(&opts.tlsCheck, "tls-check", env.GetOrDefaultBool("AUTOCERT_TLS_CHECK", false), "Confirm every hostname serves the certificate after deploying (AUTOCERT_TLS_CHECK)")
+	fs.BoolVar(&opts.forceRenew, "force-renew", env.GetOrDefaultBool("AUTOCERT_FORCE_RENEW", false), "Renew even if the certificate is still valid (AUTOCERT_FORCE_RENEW)")
+	fs.BoolVar(&opts.forceRunners, "force-runners", env.GetOrDefaultBool("AUTOCERT_FORCE_RUNNERS", false), "Run runners even if nothing was renewed (AUTOCERT_FORCE_RUNNERS)")
+	fs.BoolVar(&opts.dryRun, "dry-run", env.GetOrDefaultBool("AUTOCERT_DRY_RUN", false), "Report what would change without calling ACME or changing providers (AUTOCERT_DRY_RUN)")
+
+	return opts
+}
+
+// newConfig validates the options, loads the stored secret and creates the
+// runners. The requestor is only created on demand, see loadRequestor
+func newConfig(ctx context.Context, opts *options, needsHostnames bool, needsRunners bool) (*Config, error) {
+	log := logging.FromContext(ctx)
+
+	if opts.secretBackend == "" {
+		return nil, fmt.Errorf("secret backend not set")
+	}
+
+	if opts.secretName == "" {
+		return nil, fmt.Errorf("secret name not set")
+	}
+
+	var hostnames []string
+
+	if opts.hostnames != "" {
+		hostnames = util.NormalizeHostnames(strings.Split(opts.hostnames, ","))
+
+		log.Infof("Hostnames set: %s", hostnames)
+	}
+
+	if needsHostnames && len(hostnames) == 0 {
+		return nil, fmt.Errorf("hostnames not set")
+	}
+
+	if needsRunners && opts.runners == "" {
+		return nil, fmt.Errorf("runners not set")
+	}
+
+	var runnerManager *runner.RunnerManager
+
+	// loading runners can call provider APIs, StackPath fetches a token
+	if needsRunners {
+		runners := strings.Split(opts.runners, ",")
+
+		log.Infof("Runners set: %s", runners)
+
+		var err error
+		runnerManager, err = runner.NewRunnerManager(runners)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error loading runners: %s", err.Error())
+		}
+	}
+
+	var notifier *notify.Dispatcher
+
+	if opts.notifiers != "" {
+		notifiers, err := notify.NewNotifiers(strings.Split(opts.notifiers, ","))
+
+		if err != nil {
+			return nil, fmt.Errorf("Error loading notifiers: %s", err.Error())
+		}
+
+		notifier = notify.NewDispatcher(notifiers, strings.Split(opts.notifyEvents, ","))
+	}
+
+	recorder, err := audit.NewRecorder(opts.auditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.dryRun {
+		log.Infof("Dry-run enabled, no certificates will be requested and no providers will be changed")
+	}
+
+	var roots *x509.CertPool
+
+	if opts.caRoots != "" {
+		rootsPEM, err := os.ReadFile(opts.caRoots)
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA roots: %v", err)
+		}
+
+		roots = x509.NewCertPool()
+
+		if !roots.AppendCertsFromPEM(rootsPEM) {
+			return nil, fmt.Errorf("No certificates found in CA roots file %s", opts.caRoots)
+		}
+	}
+
+	validation := requestor.ValidateOptions{Roots: roots}
+
+	// staging roots are in no system pool, chains issued there never verify
+	if roots == nil && strings.Contains(opts.acmeURL, "staging") {
+		log.Infof("No CA roots given for staging, certificate chains will not be verified")
+		validation.SkipChain = true
+	}
+
+	// overrides apply to every TLS handshake, after deploying and when
+	// checking for drift
+	tlsOverrides, err := tlscheck.ParseOverrides(env.GetOrDefaultString("AUTOCERT_TLS_CHECK_OVERRIDES", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	var secretBackend secrets.SecretBackend
+
+	if opts.secretBackend == "secretmanager" {
+		secretBackendConfig := &secrets.SecretManagerConfig{
+			UseLatest: true,
+			ProjectId: env.GetOrDefaultString("SECRETMANAGER_GOOGLE_PROJECT_ID", ""),
+			SecretId:  opts.secretName,
+		}
+
+		secretBackend = secrets.NewSecretManagerSecretBackend(ctx, secretBackendConfig)
+	} else {
+		return nil, fmt.Errorf("Invalid secrets backend: %s", opts.secretBackend)
+	}
+
+	var tlsCheck *tlsCheckConfig
+
+	if opts.tlsCheck {
+		tlsCheck = &tlsCheckConfig{
+			window:   env.GetOrDefaultSecond("AUTOCERT_TLS_CHECK_WINDOW", 5*time.Minute),
+			interval: env.GetOrDefaultSecond("AUTOCERT_TLS_CHECK_INTERVAL", 15*time.Second),
+		}
+	}
+
+	locker, err := newLocker(opts.lock, opts.secretName, secretBackend)
+	if err != nil {
+		secretBackend.Close()
+		return nil, err
+	}
+
+	secret, err := secretBackend.GetSecret(ctx)
+	if err != nil {
+		secretBackend.Close()
+		return nil, err
+	}
+
+	return &Config{
+		secretName:     opts.secretName,
+		email:          opts.email,
+		providerName:   opts.provider,
+		acmeURL:        opts.acmeURL,
+		hostnames:      hostnames,
+		forceRenew:     opts.forceRenew,
+		forceRunners:   opts.forceRunners,
+		runnerManager:  runnerManager,
+		secretBackend:  secretBackend,
+		secret:         secret,
+		validation:     validation,
+		reissueBackoff: env.GetOrDefaultSecond("AUTOCERT_REISSUE_BACKOFF", 24*time.Hour),
+		notifier:       notifier,
+		audit:          recorder,
+		verify:         opts.verify,
+		verifyTLS:      opts.verifyTLS,
+		tlsCheck:       tlsCheck,
+		tlsOverrides:   tlsOverrides,
+		locker:         locker,
+		lockTimeout:    env.GetOrDefaultSecond("AUTOCERT_LOCK_TIMEOUT", 5*time.Minute),
+		dryRun:         opts.dryRun,
+	}, nil
+}
+
+// lockingBackend is implemented by secret backends able to hold a lock
+type lockingBackend interface {
+	NewLocker(holder string, ttl time.Duration) lock.Locker
+}
+
+// newLocker creates the lock guarding renewals of the secret, nil when
+// locking is disabled
+func newLocker(mode string, secretName string, secretBackend secrets.SecretBackend) (lock.Locker, error) {
+	holder := lock.NewHolderID()
+	ttl := env.GetOrDefaultSecond("AUTOCERT_LOCK_TTL", 30*time.Minute)
+
+	switch mode {
+	case "", "none":
+		return nil, nil
+	case "file":
+		path := env.GetOrDefaultString("AUTOCERT_LOCK_FILE", filepath.Join(os.TempDir(), fmt.Sprintf("auto-cert-%s.lock", secretName)))
+		return lock.NewFileLocker(path, holder), nil
+	case "secret":
+		backend, ok := secretBackend.(lockingBackend)
+		if !ok {
+			return nil, fmt.Errorf("Secret backend %s does not support locking", secretBackend.Name())
+		}
+		return backend.NewLocker(holder, ttl), nil
+	default:
+		return nil, fmt.Errorf("Invalid lock: %s", mode)
+	}
+}
+
+// loadRequestor creates the ACME user and requestor, registering the account
+// if the secret does not exist yet. It is a no-op in dry-run mode
+func (c *Config) loadRequestor(ctx context.Context) error {
+	if c.requestor != nil || c.dryRun {
+		return nil
+	}
+
+	var certRequestor *requestor.Requestor
+
+	if c.secret == nil {
+		logging.FromContext(ctx).Infof("User does not exist, creating new private key")
+		c.user = certRequestor.GenerateUserKeys(c.email)
+	} else {
+		userPrivateKey, err := requestor.LoadPrivateKey([]byte(c.secret.User.PrivateKey))
+
+		if err != nil {
+			return fmt.Errorf("Could not load private key: %v", err)
+		}
+
+		c.user = requestor.CreateUser(c.secret.User.Email, userPrivateKey, true)
+	}
+
+	requestorConfig := requestor.Config{
+		AcmeURL: c.acmeURL,
+	}
+
+	if c.providerName == "cloudflare" {
+		cloudflareConfig := &cloudflare.Config{}
+
+		provider, err := cloudflare.NewDNSProvider()
+
+		if err != nil {
+			return fmt.Errorf("Could not create cloudflare provider: %v", err)
+		}
+
+		certRequestor, err = requestor.NewRequestor(c.user, provider, cloudflareConfig, requestorConfig, requestor.DNS)
+
+		if err != nil {
+			return fmt.Errorf("Creating requestor failed: %v", err.Error())
+		}
+	} else {
+		return fmt.Errorf("Invalid acme provider: %s", c.providerName)
+	}
+
+	c.requestor = certRequestor
+
+	return nil
+}
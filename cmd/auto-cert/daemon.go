@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func runDaemon(args []string) error {
+	var interval, jitter time.Duration
+	var metricsPort int
+
+	ctx := context.Background()
+	log := logging.FromContext(ctx)
+
+	config, err := setup(ctx, "daemon", args, true, true, func(fs *flag.FlagSet) {
+		fs.DurationVar(&interval, "interval", env.GetOrDefaultSecond("AUTOCERT_DAEMON_INTERVAL", 12*time.Hour), "Time between checks as a duration like 12h (AUTOCERT_DAEMON_INTERVAL in seconds)")
+		fs.DurationVar(&jitter, "jitter", env.GetOrDefaultSecond("AUTOCERT_DAEMON_JITTER", 30*time.Minute), "Maximum random delay added to the interval as a duration like 30m (AUTOCERT_DAEMON_JITTER in seconds)")
+		fs.IntVar(&metricsPort, "metrics-port", env.GetOrDefaultInt("AUTOCERT_METRICS_PORT", 0), "Port serving /metrics, 0 disables it (AUTOCERT_METRICS_PORT)")
+	})
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	if interval <= 0 {
+		return fmt.Errorf("Interval must be positive, got %s", interval)
+	}
+
+	if jitter < 0 {
+		return fmt.Errorf("Jitter must not be negative, got %s", jitter)
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	if metricsPort > 0 {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+
+			log.Infof("Serving metrics on port %d", metricsPort)
+
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", metricsPort), mux); err != nil {
+				log.Errorf("Metrics listener failed: %s", err.Error())
+			}
+		}()
+	}
+
+	log.Infof("Starting auto-cert in daemon mode, checking every %s with up to %s jitter", interval, jitter)
+
+	for {
+		runCtx := newRunContext(logging.NewRunID())
+
+		result, err := withLock(runCtx, config, func() (*Result, error) {
+			return execute(runCtx, config)
+		})
+		observe(runCtx, config, nil, result, err)
+
+		if err != nil {
+			logging.FromContext(runCtx).Errorf("Renewal failed: %s", err.Error())
+		}
+
+		next := interval
+		if jitter > 0 {
+			next += time.Duration(rand.Int63n(int64(jitter)))
+		}
+
+		log.Infof("Next check at %s", time.Now().Add(next).Format(time.RFC3339))
+
+		select {
+		case <-time.After(next):
+		case sig := <-stop:
+			log.Infof("Received %s, stopping daemon", sig)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path"
+
+	"github.com/maxroll/auto-cert/pkg/audit"
+	"github.com/maxroll/auto-cert/pkg/logging"
+)
+
+func runHistory(args []string) error {
+	ctx := context.Background()
+
+	config, err := setup(ctx, "history", args, false, false, nil)
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	versions, err := config.secretBackend.ListVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(versions) == 0 {
+		fmt.Println("No versions stored")
+		return nil
+	}
+
+	fmt.Printf("%-8s %-10s %-25s %s\n", "VERSION", "STATE", "CREATED", "")
+
+	for _, version := range versions {
+		current := ""
+		if config.secret != nil && version.Name == config.secret.Version {
+			current = "current"
+		}
+
+		fmt.Printf("%-8s %-10s %-25s %s\n", path.Base(version.Name), version.State, version.CreatedAt.Format("2006-01-02 15:04:05 MST"), current)
+	}
+
+	return nil
+}
+
+func runRollback(args []string) error {
+	var version string
+
+	ctx := newRunContext(logging.NewRunID())
+	log := logging.FromContext(ctx)
+
+	config, err := setup(ctx, "rollback", args, false, true, func(fs *flag.FlagSet) {
+		fs.StringVar(&version, "version", "", "Secret version to roll back to, see auto-cert history")
+	})
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	if version == "" {
+		return fmt.Errorf("version not set")
+	}
+
+	result, err := withLock(ctx, config, func() (*Result, error) {
+		if config.dryRun {
+			log.Infof("[dry-run] Would roll back secret %s to version %s and deploy it", config.secretName, version)
+			return &Result{Action: actionPlanned}, nil
+		}
+
+		secret, err := config.secretBackend.Rollback(ctx, version)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to roll back secret: %v", err)
+		}
+
+		config.secret = secret
+		config.hostnames = secret.Hostnames
+
+		certificate, _, err := storedCertificate(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		event := audit.NewEvent(audit.Rollback, config.secretName, config.hostnames, certificate.Certificate)
+		event.Detail = fmt.Sprintf("rolled back to version %s", version)
+		recordAudit(ctx, config, event)
+
+		log.Infof("Rolled back secret %s to version %s", config.secretName, version)
+
+		result, err := deploy(ctx, config, certificate)
+		if result != nil {
+			result.Action = actionRolledBack
+		}
+
+		return result, err
+	})
+	observe(ctx, config, nil, result, err)
+
+	return err
+}
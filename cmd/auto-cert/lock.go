@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/maxroll/auto-cert/pkg/lock"
+	"github.com/maxroll/auto-cert/pkg/logging"
+)
+
+// lockRetryInterval is the time between attempts to acquire a held lock
+const lockRetryInterval = 10 * time.Second
+
+// withLock runs fn while holding the configured lock so renewing, storing and
+// deploying the certificate never interleave with another instance. The
+// secret is reloaded once the lock is held as the holder before may have
+// changed it. Dry-runs change nothing and do not lock.
+func withLock(ctx context.Context, config *Config, fn func() (*Result, error)) (*Result, error) {
+	if config.locker == nil || config.dryRun {
+		return fn()
+	}
+
+	log := logging.FromContext(ctx)
+
+	log.Debugf("Acquiring %s lock", config.locker.Name())
+
+	if err := lock.Acquire(ctx, config.locker, config.lockTimeout, lockRetryInterval); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := config.locker.Unlock(ctx); err != nil {
+			log.Warnf("Could not release %s lock: %v", config.locker.Name(), err)
+		}
+	}()
+
+	var err error
+
+	config.secret, err = config.secretBackend.GetSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return fn()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/maxroll/auto-cert/pkg/audit"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/metrics"
+	"github.com/maxroll/auto-cert/pkg/notify"
+	"github.com/maxroll/auto-cert/pkg/status"
+)
+
+// observe records the outcome of a run in the metrics, the notifiers and,
+// when given, the status tracker
+func observe(ctx context.Context, config *Config, tracker *status.Tracker, result *Result, err error) {
+	if err != nil {
+		metrics.Failure(config.secretName)
+	}
+
+	notifyResult(ctx, config, result, err)
+
+	if tracker != nil {
+		if result != nil {
+			tracker.RecordAttempt(config.secretName, result.Action, err, result.Runners)
+		} else {
+			tracker.RecordAttempt(config.secretName, "", err, nil)
+		}
+
+		if err := tracker.SetSecret(config.secretName, config.secret); err != nil {
+			logging.FromContext(ctx).Warnf("Could not read stored certificate: %s", err.Error())
+		}
+	}
+
+	recordExpiry(ctx, config)
+}
+
+// notifyResult sends the outcome of a run to the notifiers
+func notifyResult(ctx context.Context, config *Config, result *Result, err error) {
+	event := notify.Event{
+		Secret:    config.secretName,
+		Hostnames: config.hostnames,
+		Time:      time.Now(),
+	}
+
+	if result != nil {
+		event.Kind = notify.Kind(result.Action)
+		event.Runners = result.Runners
+	}
+
+	if err != nil {
+		event.Kind = notify.Failed
+		event.Error = err.Error()
+	}
+
+	config.notifier.Dispatch(ctx, event)
+}
+
+// recordExpiry exports the expiry of the stored certificate
+func recordExpiry(ctx context.Context, config *Config) {
+	if config.secret == nil {
+		return
+	}
+
+	_, cert, certErr := storedCertificate(config.secret)
+	if certErr != nil {
+		logging.FromContext(ctx).Warnf("Could not read stored certificate: %s", certErr.Error())
+		return
+	}
+
+	metrics.SetCertificateExpiry(config.secretName, config.secret.Hostnames, cert.NotAfter)
+}
+
+// recordAudit appends the event to the audit trail, if configured
+func recordAudit(ctx context.Context, config *Config, event audit.Event) {
+	if config.audit == nil {
+		return
+	}
+
+	event.RunID = logging.RunID(ctx)
+
+	if err := config.audit.Record(event); err != nil {
+		logging.FromContext(ctx).Errorf("Could not record %s in audit log: %s", event.Type, err.Error())
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maxroll/auto-cert/pkg/audit"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/metrics"
+	"github.com/maxroll/auto-cert/pkg/requestor"
+	"github.com/maxroll/auto-cert/pkg/runner"
+	"github.com/maxroll/auto-cert/pkg/secrets"
+	"github.com/maxroll/auto-cert/pkg/tlscheck"
+	"github.com/maxroll/auto-cert/pkg/util"
+)
+
+// storedCertificate returns the certificate stored in the secret together
+// with its parsed leaf certificate
+func storedCertificate(secret *secrets.Secret) (*requestor.Certificate, *x509.Certificate, error) {
+	certificate := &requestor.Certificate{
+		Certificate: []byte(secret.Certificate),
+		PrivateKey:  []byte(secret.PrivateKey),
+	}
+
+	block, _ := pem.Decode(certificate.Certificate)
+	if block == nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate PEM")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate: %v", err.Error())
+	}
+
+	return certificate, cert, nil
+}
+
+// renewBefore is the remaining validity at which certificates are renewed
+const renewBefore = 72 * time.Hour
+
+const (
+	actionIssued     = "issued"
+	actionRenewed    = "renewed"
+	actionSkipped    = "skipped"
+	actionDeployed   = "deployed"
+	actionPlanned    = "planned"
+	actionRolledBack = "rolled_back"
+)
+
+// Result describes what a run did
+type Result struct {
+	Action  string          `json:"action"`
+	Runners []runner.Result `json:"runners,omitempty"`
+	// Verifications is set when the deployments were checked for drift
+	Verifications []runner.Verification `json:"verifications,omitempty"`
+	// TLSChecks is set when the served certificate was checked after deploying
+	TLSChecks []tlscheck.Check `json:"tls_checks,omitempty"`
+}
+
+// execute renews the stored certificate when needed, or requests a new one if
+// no secret exists, and runs the runners afterwards
+func execute(ctx context.Context, config *Config) (*Result, error) {
+	var err error
+	log := logging.FromContext(ctx)
+
+	// reload the secret, it may have changed since the last run
+	config.secret, err = config.secretBackend.GetSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.secret == nil {
+		return issue(ctx, config)
+	}
+
+	certificate, cert, err := storedCertificate(config.secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.forceRenew {
+		log.Warnf("Forcibly renewing certificate")
+	}
+
+	changed := hostnamesChanged(ctx, config, cert)
+
+	due := cert.NotAfter.Sub(time.Now()) < renewBefore || config.forceRenew || changed
+
+	validationErr := requestor.ValidateCertificate(ctx, certificate, config.hostnames, config.validation)
+	if validationErr != nil && !due {
+		// a failure that survives reissuing would otherwise reissue on every
+		// run until the CA rate limits kick in
+		if last := config.secret.ValidationReissuedAt; last != nil && time.Since(*last) < config.reissueBackoff {
+			log.Errorf("Stored certificate failed validation: %v, not reissuing again before %s", validationErr, last.Add(config.reissueBackoff))
+			return &Result{Action: actionSkipped}, nil
+		}
+
+		log.Warnf("Stored certificate failed validation: %v, reissuing certificate", validationErr)
+	}
+
+	if !due && validationErr == nil {
+
+		log.Infof("Validity left: %d days", int(cert.NotAfter.Sub(time.Now()).Hours())/24)
+		log.Infof("Current certicate valid until: %s. No need to renew", cert.NotAfter)
+
+		if config.forceRunners {
+			return deploy(ctx, config, certificate)
+		}
+
+		if config.verify {
+			return verifyDeployments(ctx, config, certificate, true)
+		}
+
+		return &Result{Action: actionSkipped}, nil
+	}
+
+	if config.dryRun {
+		log.Infof("[dry-run] Would renew certificate for %s", config.hostnames)
+		config.runnerManager.Plan(ctx, config.hostnames, certificate)
+		return &Result{Action: actionPlanned}, nil
+	}
+
+	if err := config.loadRequestor(ctx); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Renewing certificate")
+
+	if errors.Is(validationErr, requestor.ErrInvalidKey) {
+		// the stored key is unusable, request a certificate with a fresh key
+		metrics.ACMEOrder("new")
+		certificate, err = config.requestor.GenerateCertificate(ctx, config.user, config.hostnames)
+	} else {
+		metrics.ACMEOrder("renew")
+		certificate, err = config.requestor.RenewCertificate(ctx, config.user, config.hostnames, certificate.PrivateKey)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to renew certificate: %v", err.Error())
+	}
+
+	reissuedAt := config.secret.ValidationReissuedAt
+	if validationErr != nil && !due {
+		now := time.Now()
+		reissuedAt = &now
+	}
+
+	config.secret, err = config.secretBackend.UpdateSecret(ctx, config.secret.Version, &secrets.Secret{
+		Certificate:          string(certificate.Certificate),
+		PrivateKey:           string(certificate.PrivateKey),
+		User:                 config.secret.User,
+		Hostnames:            config.hostnames,
+		ValidationReissuedAt: reissuedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.Renewal(config.secretName)
+
+	if errors.Is(validationErr, requestor.ErrInvalidKey) {
+		recordAudit(ctx, config, audit.NewEvent(audit.Issuance, config.secretName, config.hostnames, certificate.Certificate))
+		keyRotation := audit.NewEvent(audit.KeyRotation, config.secretName, config.hostnames, certificate.Certificate)
+		keyRotation.Detail = "stored private key unusable, replaced with a new key"
+		recordAudit(ctx, config, keyRotation)
+	} else {
+		recordAudit(ctx, config, audit.NewEvent(audit.Renewal, config.secretName, config.hostnames, certificate.Certificate))
+	}
+
+	log.Infof("Certificate renewed successfully")
+
+	result, err := deploy(ctx, config, certificate)
+	if result != nil {
+		result.Action = actionRenewed
+	}
+
+	return result, err
+}
+
+// issue requests a new certificate with a fresh private key, stores it and
+// runs the runners
+func issue(ctx context.Context, config *Config) (*Result, error) {
+	log := logging.FromContext(ctx)
+
+	if config.dryRun {
+		log.Infof("[dry-run] Would request new certificate for %s", config.hostnames)
+		config.runnerManager.Plan(ctx, config.hostnames, nil)
+		return &Result{Action: actionPlanned}, nil
+	}
+
+	if err := config.loadRequestor(ctx); err != nil {
+		return nil, err
+	}
+
+	// request new certificate
+	metrics.ACMEOrder("new")
+	certificate, err := config.requestor.GenerateCertificate(ctx, config.user, config.hostnames)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request certificate: %v", err)
+	}
+
+	replacesKey := config.secret != nil
+
+	if config.secret == nil {
+		userKeyBytes := requestor.GetPrivateKeyBytes(config.user.GetPrivateKey())
+		config.secret, err = config.secretBackend.CreateSecret(ctx, &secrets.Secret{
+			Certificate: string(certificate.Certificate),
+			PrivateKey:  string(certificate.PrivateKey),
+			User: secrets.User{
+				Email:      config.email,
+				PrivateKey: string(userKeyBytes),
+			},
+			Hostnames: config.hostnames,
+		})
+	} else {
+		config.secret, err = config.secretBackend.UpdateSecret(ctx, config.secret.Version, &secrets.Secret{
+			Certificate:          string(certificate.Certificate),
+			PrivateKey:           string(certificate.PrivateKey),
+			User:                 config.secret.User,
+			Hostnames:            config.hostnames,
+			ValidationReissuedAt: config.secret.ValidationReissuedAt,
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.Renewal(config.secretName)
+	recordAudit(ctx, config, audit.NewEvent(audit.Issuance, config.secretName, config.hostnames, certificate.Certificate))
+
+	if replacesKey {
+		keyRotation := audit.NewEvent(audit.KeyRotation, config.secretName, config.hostnames, certificate.Certificate)
+		keyRotation.Detail = "certificate issued with a new private key"
+		recordAudit(ctx, config, keyRotation)
+	}
+
+	log.Infof("Done requesting cerficate for %s", config.hostnames)
+
+	result, err := deploy(ctx, config, certificate)
+	if result != nil {
+		result.Action = actionIssued
+	}
+
+	return result, err
+}
+
+// deploy runs all runners for the certificate, or reports what they would do
+// in dry-run mode
+func deploy(ctx context.Context, config *Config, certificate *requestor.Certificate) (*Result, error) {
+	return deployTo(ctx, config, config.runnerManager, certificate)
+}
+
+// deployTo deploys the certificate with the runners of manager
+func deployTo(ctx context.Context, config *Config, manager *runner.RunnerManager, certificate *requestor.Certificate) (*Result, error) {
+	if config.dryRun {
+		manager.Plan(ctx, config.hostnames, certificate)
+		return &Result{Action: actionPlanned}, nil
+	}
+
+	runners := manager.Run(ctx, config.hostnames, certificate)
+
+	for _, result := range runners {
+		event := audit.NewEvent(audit.Deployment, config.secretName, config.hostnames, certificate.Certificate)
+		event.Runner = result.Runner
+		event.Target = result.Target
+		event.Detail = strings.Join(result.Changes, "; ")
+		event.Error = result.Error
+		recordAudit(ctx, config, event)
+	}
+
+	result := &Result{Action: actionDeployed, Runners: runners}
+
+	// the certificate is stored, but the run still fails so schedulers retry
+	if failed := runner.Failed(runners); len(failed) > 0 {
+		names := make([]string, len(failed))
+		for i, f := range failed {
+			names[i] = f.Runner
+		}
+
+		return result, fmt.Errorf("%d of %d runners failed: %s", len(failed), len(runners), strings.Join(names, ", "))
+	}
+
+	if config.tlsCheck != nil {
+		checks, err := checkServed(ctx, config, certificate)
+		result.TLSChecks = checks
+
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// hostnamesChanged reports whether the configured hostnames differ from the
+// ones stored with the secret or from the SANs of the stored certificate.
+func hostnamesChanged(ctx context.Context, config *Config, cert *x509.Certificate) bool {
+	log := logging.FromContext(ctx)
+
+	// hostnames stored by older versions may not be normalized yet
+	if !util.StringSlicesEqual(util.NormalizeHostnames(config.secret.Hostnames), config.hostnames) {
+		log.Infof("Configured hostnames %s differ from stored hostnames %s, reissuing certificate", config.hostnames, config.secret.Hostnames)
+		return true
+	}
+
+	if !util.StringSlicesEqual(util.NormalizeHostnames(cert.DNSNames), config.hostnames) {
+		log.Infof("Configured hostnames %s differ from certificate SANs %s, reissuing certificate", config.hostnames, cert.DNSNames)
+		return true
+	}
+
+	return false
+}
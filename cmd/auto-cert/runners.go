@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/maxroll/auto-cert/pkg/runner"
+)
+
+func runRunners(args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintf(os.Stderr, "Usage: auto-cert runners list\n")
+		return fmt.Errorf("unknown runners command")
+	}
+
+	for i, factory := range runner.Factories() {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("%s\n  %s\n", factory.Name, factory.Description)
+
+		for _, setting := range factory.Settings {
+			var notes []string
+
+			if setting.Required {
+				notes = append(notes, "required")
+			}
+
+			if setting.Default != "" {
+				notes = append(notes, fmt.Sprintf("default %s", setting.Default))
+			}
+
+			note := ""
+			if len(notes) > 0 {
+				note = fmt.Sprintf(" (%s)", strings.Join(notes, ", "))
+			}
+
+			fmt.Printf("  %-30s %s%s\n", setting.Env, setting.Description, note)
+		}
+	}
+
+	fmt.Printf("\nEvery runner also reads <RUNNER>_RETRY_ATTEMPTS, <RUNNER>_RETRY_BACKOFF,\n<RUNNER>_RETRY_MAX_BACKOFF, <RUNNER>_TIMEOUT and <RUNNER>_HOSTNAMES\n")
+	fmt.Printf("\nInstances configured as <runner>:<name> read <RUNNER>_<NAME>_<SETTING>, falling\nback to <RUNNER>_<SETTING>\n")
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/requestor"
+	"github.com/maxroll/auto-cert/pkg/runner"
+	"github.com/maxroll/auto-cert/pkg/tlscheck"
+)
+
+func runVerify(args []string) error {
+	var redeploy bool
+
+	ctx := newRunContext(logging.NewRunID())
+
+	config, err := setup(ctx, "verify", args, true, true, func(fs *flag.FlagSet) {
+		fs.BoolVar(&redeploy, "redeploy", env.GetOrDefaultBool("AUTOCERT_VERIFY_REDEPLOY", true), "Redeploy to runners with drift instead of failing (AUTOCERT_VERIFY_REDEPLOY)")
+	})
+	if err != nil {
+		return err
+	}
+	defer config.secretBackend.Close()
+
+	result, err := withLock(ctx, config, func() (*Result, error) {
+		if config.secret == nil {
+			return nil, fmt.Errorf("secret does not exist, nothing to verify")
+		}
+
+		certificate, _, err := storedCertificate(config.secret)
+		if err != nil {
+			return nil, err
+		}
+
+		return verifyDeployments(ctx, config, certificate, redeploy)
+	})
+	observe(ctx, config, nil, result, err)
+
+	return err
+}
+
+// verifyDeployments compares the certificate deployed by every runner and,
+// when enabled, the certificate served for every hostname with the stored
+// one. Runners with drift are deployed again if redeploy is set, otherwise
+// drift fails the run
+func verifyDeployments(ctx context.Context, config *Config, certificate *requestor.Certificate, redeploy bool) (*Result, error) {
+	log := logging.FromContext(ctx)
+
+	leaf, err := certcrypto.ParsePEMCertificate(certificate.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	verifications := config.runnerManager.Verify(ctx, config.hostnames, certificate)
+
+	for _, verification := range verifications {
+		runnerLog := log.With("runner", verification.Runner)
+
+		switch {
+		case verification.Unsupported:
+			runnerLog.Debugf("Runner %s can not report its deployment", verification.Runner)
+		case verification.Error != "":
+			runnerLog.Warnf("Could not verify runner %s: %s", verification.Runner, verification.Error)
+		case len(verification.Drift) > 0:
+			runnerLog.Warnf("Runner %s drifted: %s", verification.Runner, strings.Join(verification.Drift, "; "))
+		default:
+			runnerLog.Infof("Runner %s serves the stored certificate", verification.Runner)
+		}
+	}
+
+	drifted := driftedRunners(verifications)
+
+	if config.verifyTLS {
+		var mismatched []string
+
+		for _, hostname := range config.hostnames {
+			if !tlscheck.Checkable(hostname) {
+				continue
+			}
+
+			target := tlscheck.TargetFor(hostname, config.tlsOverrides)
+
+			served, err := tlscheck.Fetch(ctx, target.ServerName, target.Address)
+			if err != nil {
+				// an unreachable hostname is no reason to redeploy
+				log.Warnf("Could not check certificate served for %s: %v", hostname, err)
+				continue
+			}
+
+			if diff := tlscheck.Compare(served, leaf); diff != "" {
+				log.Warnf("%s %s", hostname, diff)
+				mismatched = append(mismatched, hostname)
+			}
+		}
+
+		// only the runners deploying a mismatched hostname can be the cause
+		for _, name := range config.runnerManager.Serving(mismatched) {
+			if !contains(drifted, name) {
+				drifted = append(drifted, name)
+			}
+		}
+	}
+
+	if len(drifted) == 0 {
+		log.Infof("No deployment drift found")
+		return &Result{Action: actionSkipped, Verifications: verifications}, nil
+	}
+
+	if !redeploy {
+		return &Result{Action: actionSkipped, Verifications: verifications}, fmt.Errorf("deployment drift detected for %s", strings.Join(drifted, ", "))
+	}
+
+	log.Infof("Redeploying certificate to %s", strings.Join(drifted, ", "))
+
+	result, err := deployTo(ctx, config, config.runnerManager.Subset(drifted), certificate)
+	if result != nil {
+		result.Verifications = verifications
+	}
+
+	return result, err
+}
+
+// driftedRunners returns the names of the runners that need to be deployed
+func driftedRunners(verifications []runner.Verification) []string {
+	var names []string
+
+	for _, verification := range verifications {
+		if verification.Drifted() {
+			names = append(names, verification.Runner)
+		}
+	}
+
+	return names
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkServed waits until every hostname serves the deployed certificate,
+// CDNs can take a while to roll a certificate out to all edges
+func checkServed(ctx context.Context, config *Config, certificate *requestor.Certificate) ([]tlscheck.Check, error) {
+	log := logging.FromContext(ctx)
+
+	leaf, err := certcrypto.ParsePEMCertificate(certificate.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []tlscheck.Target
+
+	for _, hostname := range config.hostnames {
+		if !tlscheck.Checkable(hostname) {
+			log.Debugf("Not checking wildcard hostname %s", hostname)
+			continue
+		}
+
+		targets = append(targets, tlscheck.TargetFor(hostname, config.tlsOverrides))
+	}
+
+	checks := make([]tlscheck.Check, len(targets))
+
+	var wg sync.WaitGroup
+
+	for i, target := range targets {
+		wg.Add(1)
+
+		go func(i int, target tlscheck.Target) {
+			defer wg.Done()
+
+			checks[i] = tlscheck.WaitForSerial(ctx, target, leaf, config.tlsCheck.window, config.tlsCheck.interval)
+		}(i, target)
+	}
+
+	wg.Wait()
+
+	var failed []string
+
+	for _, check := range checks {
+		if check.Success {
+			log.Infof("%s serves the deployed certificate", check.Hostname)
+		} else {
+			log.Errorf("%s does not serve the deployed certificate after %d attempts: %s", check.Hostname, check.Attempts, check.Error)
+			failed = append(failed, check.Hostname)
+		}
+	}
+
+	if len(failed) > 0 {
+		return checks, fmt.Errorf("deployed certificate not served for %s", strings.Join(failed, ", "))
+	}
+
+	return checks, nil
+}
@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/maxroll/auto-cert/pkg/util"
+)
+
+type EventType string
+
+const (
+	Issuance    EventType = "issuance"
+	Renewal     EventType = "renewal"
+	KeyRotation EventType = "key_rotation"
+	Deployment  EventType = "deployment"
+	Rollback    EventType = "rollback"
+)
+
+// Event is a single entry of the audit trail
+type Event struct {
+	Time        time.Time  `json:"time"`
+	Type        EventType  `json:"type"`
+	RunID       string     `json:"run_id,omitempty"`
+	Secret      string     `json:"secret"`
+	Hostnames   []string   `json:"hostnames,omitempty"`
+	Serial      string     `json:"serial,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	Runner      string     `json:"runner,omitempty"`
+	Target      string     `json:"target,omitempty"`
+	Detail      string     `json:"detail,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// NewEvent creates an event for the leaf of the PEM bundle, certificate
+// details are left empty if the bundle can not be parsed
+func NewEvent(eventType EventType, secret string, hostnames []string, bundle []byte) Event {
+	event := Event{
+		Time:      time.Now().UTC(),
+		Type:      eventType,
+		Secret:    secret,
+		Hostnames: hostnames,
+	}
+
+	if certificates, err := certcrypto.ParsePEMBundle(bundle); err == nil {
+		leaf := certificates[0]
+		notAfter := leaf.NotAfter
+
+		event.Serial = leaf.SerialNumber.Text(16)
+		event.Fingerprint = util.Fingerprint(leaf)
+		event.NotAfter = &notAfter
+	}
+
+	return event
+}
+
+type Recorder interface {
+	Record(event Event) error
+}
+
+// NewRecorder creates the recorder for the destination, an empty destination
+// disables the audit trail
+func NewRecorder(destination string) (Recorder, error) {
+	if destination == "" {
+		return nil, nil
+	}
+
+	if path := strings.TrimPrefix(destination, "file://"); path != destination || !strings.Contains(destination, "://") {
+		return NewFileRecorder(path)
+	}
+
+	return nil, fmt.Errorf("Unsupported audit destination: %s", destination)
+}
+
+// FileRecorder appends events as JSON lines to a local file
+type FileRecorder struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileRecorder(path string) (*FileRecorder, error) {
+	// make sure the file can be written before the first event
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open audit log: %v", err)
+	}
+
+	return &FileRecorder{path: path}, f.Close()
+}
+
+func (r *FileRecorder) Record(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/maxroll/auto-cert/pkg/logging"
+)
+
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+	Name() string
+}
+
+// NewAuthenticator creates the authenticator for the given mode, each
+// authenticator reads its own settings from the environment
+func NewAuthenticator(mode string) (Authenticator, error) {
+	switch mode {
+	case "", "none":
+		return nil, nil
+	case "bearer":
+		return NewBearerAuthenticator()
+	case "hmac":
+		return NewHMACAuthenticator()
+	case "oidc":
+		return NewOIDCAuthenticator()
+	}
+
+	return nil, fmt.Errorf("Unknown auth mode: %s", mode)
+}
+
+// Middleware rejects requests the authenticator does not accept, a nil
+// authenticator allows all requests
+func Middleware(authenticator Authenticator, next http.Handler) http.Handler {
+	if authenticator == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := authenticator.Authenticate(r); err != nil {
+			logging.FromContext(r.Context()).With("auth", authenticator.Name()).Warnf("Rejected request to %s from %s: %s", r.URL.Path, r.RemoteAddr, err.Error())
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
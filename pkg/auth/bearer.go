@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+)
+
+type BearerAuthenticator struct {
+	token string
+}
+
+func NewBearerAuthenticator() (*BearerAuthenticator, error) {
+	token := env.GetOrDefaultString("AUTOCERT_AUTH_TOKEN", "")
+
+	if token == "" {
+		return nil, fmt.Errorf("env var AUTOCERT_AUTH_TOKEN not set")
+	}
+
+	return &BearerAuthenticator{token}, nil
+}
+
+func (a *BearerAuthenticator) Name() string {
+	return "bearer"
+}
+
+func (a *BearerAuthenticator) Authenticate(r *http.Request) error {
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+
+	return nil
+}
+
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", fmt.Errorf("missing bearer token")
+	}
+
+	return strings.TrimPrefix(header, "Bearer "), nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+)
+
+const (
+	SignatureHeader = "X-Autocert-Signature"
+	TimestampHeader = "X-Autocert-Timestamp"
+
+	// maxBodySize limits the body read into memory before the signature is
+	// checked, requests only carry a short list of hostnames
+	maxBodySize = 64 << 10
+)
+
+// HMACAuthenticator verifies requests signed with a shared secret. The
+// signature is the hex encoded HMAC-SHA256 of "<timestamp>.<method>.<path>.<body>"
+// where timestamp is the unix time sent in the timestamp header
+type HMACAuthenticator struct {
+	secret []byte
+	maxAge time.Duration
+}
+
+func NewHMACAuthenticator() (*HMACAuthenticator, error) {
+	secret := env.GetOrDefaultString("AUTOCERT_AUTH_HMAC_SECRET", "")
+
+	if secret == "" {
+		return nil, fmt.Errorf("env var AUTOCERT_AUTH_HMAC_SECRET not set")
+	}
+
+	maxAge := env.GetOrDefaultSecond("AUTOCERT_AUTH_HMAC_MAX_AGE", 5*time.Minute)
+
+	return &HMACAuthenticator{[]byte(secret), maxAge}, nil
+}
+
+func (a *HMACAuthenticator) Name() string {
+	return "hmac"
+}
+
+func (a *HMACAuthenticator) Authenticate(r *http.Request) error {
+	signature, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("missing or malformed signature")
+	}
+
+	timestamp := r.Header.Get(TimestampHeader)
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or malformed timestamp")
+	}
+
+	age := time.Since(time.Unix(unix, 0))
+	if age > a.maxAge || age < -a.maxAge {
+		return fmt.Errorf("timestamp outside of allowed window")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("could not read body of at most %d bytes: %w", maxBodySize, err)
+	}
+	r.Body.Close()
+
+	// restore the body for the handler
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !hmac.Equal(signature, Sign(a.secret, timestamp, r.Method, r.URL.Path, body)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
+
+// Sign computes the signature for a request, used by clients calling the
+// listener
+func Sign(secret []byte, timestamp string, method string, path string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s.%s.%s.", timestamp, method, path)
+	mac.Write(body)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACAuthenticator(t *testing.T) {
+	secret := []byte("shared-secret")
+	authenticator := &HMACAuthenticator{secret: secret, maxAge: 5 * time.Minute}
+
+	body := `{"hostnames":["example.com"]}`
+	large := strings.Repeat("a", maxBodySize+1)
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		signWith  []byte
+		signBody  string
+		sendBody  string
+		signPath  string
+		wantErr   string
+	}{
+		{"valid", time.Now(), secret, body, body, "/cert", ""},
+		{"small clock skew", time.Now().Add(time.Minute), secret, body, body, "/cert", ""},
+		{"too old", time.Now().Add(-6 * time.Minute), secret, body, body, "/cert", "outside of allowed window"},
+		{"too far in the future", time.Now().Add(6 * time.Minute), secret, body, body, "/cert", "outside of allowed window"},
+		{"tampered body", time.Now(), secret, body, `{"hostnames":["evil.com"]}`, "/cert", "invalid signature"},
+		{"other path", time.Now(), secret, body, body, "/status", "invalid signature"},
+		{"wrong secret", time.Now(), []byte("other-secret"), body, body, "/cert", "invalid signature"},
+		{"body too large", time.Now(), secret, large, large, "/cert", "at most"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp := strconv.FormatInt(tt.timestamp.Unix(), 10)
+
+			r := httptest.NewRequest("POST", "/cert", strings.NewReader(tt.sendBody))
+			r.Header.Set(TimestampHeader, timestamp)
+			r.Header.Set(SignatureHeader, hex.EncodeToString(Sign(tt.signWith, timestamp, "POST", tt.signPath, []byte(tt.signBody))))
+
+			err := authenticator.Authenticate(r)
+			assertError(t, err, tt.wantErr)
+
+			if err != nil {
+				return
+			}
+
+			// the handler still needs to read the body
+			restored, err := io.ReadAll(r.Body)
+			if err != nil || string(restored) != tt.sendBody {
+				t.Errorf("body not restored: %q, %v", restored, err)
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticatorMalformedHeaders(t *testing.T) {
+	authenticator := &HMACAuthenticator{secret: []byte("shared-secret"), maxAge: 5 * time.Minute}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		wantErr   string
+	}{
+		{"missing signature", "", timestamp, "signature"},
+		{"signature not hex", "zz", timestamp, "signature"},
+		{"missing timestamp", "abcd", "", "timestamp"},
+		{"timestamp not a number", "abcd", "yesterday", "timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/cert", nil)
+			r.Header.Set(SignatureHeader, tt.signature)
+			r.Header.Set(TimestampHeader, tt.timestamp)
+
+			assertError(t, authenticator.Authenticate(r), tt.wantErr)
+		})
+	}
+}
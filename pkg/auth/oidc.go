@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+)
+
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// OIDCAuthenticator verifies Google-signed ID tokens, as sent by Cloud
+// Scheduler and Pub/Sub push subscriptions, against a local JWKS file
+type OIDCAuthenticator struct {
+	keys     map[string]*rsa.PublicKey
+	audience string
+	email    string
+}
+
+type jwks struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Alg string `json:"alg"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	ExpiresAt     int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+}
+
+func NewOIDCAuthenticator() (*OIDCAuthenticator, error) {
+	jwksFile := env.GetOrDefaultString("AUTOCERT_AUTH_JWKS_FILE", "")
+	audience := env.GetOrDefaultString("AUTOCERT_AUTH_AUDIENCE", "")
+
+	if jwksFile == "" {
+		return nil, fmt.Errorf("env var AUTOCERT_AUTH_JWKS_FILE not set")
+	}
+
+	if audience == "" {
+		return nil, fmt.Errorf("env var AUTOCERT_AUTH_AUDIENCE not set")
+	}
+
+	keys, err := loadJWKS(jwksFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCAuthenticator{
+		keys:     keys,
+		audience: audience,
+		email:    env.GetOrDefaultString("AUTOCERT_AUTH_EMAIL", ""),
+	}, nil
+}
+
+func (a *OIDCAuthenticator) Name() string {
+	return "oidc"
+}
+
+func (a *OIDCAuthenticator) Authenticate(r *http.Request) error {
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return err
+	}
+
+	if !contains(googleIssuers, claims.Issuer) {
+		return fmt.Errorf("unexpected issuer %s", claims.Issuer)
+	}
+
+	if claims.Audience != a.audience {
+		return fmt.Errorf("unexpected audience %s", claims.Audience)
+	}
+
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("token has no expiry")
+	}
+
+	// allow for some clock skew
+	now := time.Now()
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(time.Minute)) {
+		return fmt.Errorf("token expired")
+	}
+
+	if claims.IssuedAt != 0 && now.Add(time.Minute).Before(time.Unix(claims.IssuedAt, 0)) {
+		return fmt.Errorf("token issued in the future")
+	}
+
+	if a.email != "" && (claims.Email != a.email || !claims.EmailVerified) {
+		return fmt.Errorf("unexpected email %s", claims.Email)
+	}
+
+	return nil
+}
+
+// verify checks the RS256 signature of the token and returns its claims
+func (a *OIDCAuthenticator) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported algorithm %s", header.Alg)
+	}
+
+	key, ok := a.keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %v", err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+
+	return &claims, nil
+}
+
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read JWKS file: %v", err)
+	}
+
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("Could not parse JWKS file: %v", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid modulus for key %s: %v", key.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exponent for key %s: %v", key.Kid, err)
+		}
+
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No RSA keys found in JWKS file %s", path)
+	}
+
+	return keys, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
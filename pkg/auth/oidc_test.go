@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAudience = "https://auto-cert.example.com/cert"
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signToken creates an RS256 token, or an unsigned one for alg none
+func signToken(t *testing.T, key *rsa.PrivateKey, header map[string]string, claims map[string]interface{}) string {
+	t.Helper()
+
+	signed := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+
+	var signature []byte
+
+	switch header["alg"] {
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "HS256":
+		// signed with the public key as HMAC secret, the classic confusion
+		mac := hmac.New(sha256.New, key.PublicKey.N.Bytes())
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+
+	return map[string]interface{}{
+		"iss":            "https://accounts.google.com",
+		"aud":            testAudience,
+		"email":          "scheduler@project.iam.gserviceaccount.com",
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	key := generateKey(t)
+	otherKey := generateKey(t)
+
+	authenticator := &OIDCAuthenticator{
+		keys:     map[string]*rsa.PublicKey{"key-1": &key.PublicKey},
+		audience: testAudience,
+		email:    "scheduler@project.iam.gserviceaccount.com",
+	}
+
+	header := map[string]string{"alg": "RS256", "kid": "key-1"}
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"valid", signToken(t, key, header, validClaims()), ""},
+		{"legacy issuer", signToken(t, key, header, with("iss", "accounts.google.com")), ""},
+		{"alg none", signToken(t, key, map[string]string{"alg": "none", "kid": "key-1"}, validClaims()), "unsupported algorithm"},
+		{"alg HS256", signToken(t, key, map[string]string{"alg": "HS256", "kid": "key-1"}, validClaims()), "unsupported algorithm"},
+		{"unknown kid", signToken(t, key, map[string]string{"alg": "RS256", "kid": "key-2"}, validClaims()), "unknown key id"},
+		{"bad signature", signToken(t, otherKey, header, validClaims()), "invalid signature"},
+		{"tampered claims", tamper(t, signToken(t, key, header, validClaims())), "invalid signature"},
+		{"malformed", "not-a-token", "malformed token"},
+		{"expired", signToken(t, key, header, with("exp", time.Now().Add(-2*time.Minute).Unix())), "expired"},
+		{"expired within leeway", signToken(t, key, header, with("exp", time.Now().Add(-30*time.Second).Unix())), ""},
+		{"missing exp", signToken(t, key, header, with("exp", nil)), "no expiry"},
+		{"issued in the future", signToken(t, key, header, with("iat", time.Now().Add(5*time.Minute).Unix())), "future"},
+		{"wrong audience", signToken(t, key, header, with("aud", "https://other.example.com")), "unexpected audience"},
+		{"wrong issuer", signToken(t, key, header, with("iss", "https://issuer.example.com")), "unexpected issuer"},
+		{"unverified email", signToken(t, key, header, with("email_verified", false)), "unexpected email"},
+		{"wrong email", signToken(t, key, header, with("email", "someone@example.com")), "unexpected email"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/cert", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			assertError(t, authenticator.Authenticate(r), tt.wantErr)
+		})
+	}
+
+	t.Run("missing bearer token", func(t *testing.T) {
+		assertError(t, authenticator.Authenticate(httptest.NewRequest("POST", "/cert", nil)), "missing bearer token")
+	})
+}
+
+// tamper replaces the claims of a signed token, keeping its signature
+func tamper(t *testing.T, token string) string {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	claims := validClaims()
+	claims["email"] = "attacker@example.com"
+	parts[1] = encodeSegment(t, claims)
+
+	return strings.Join(parts, ".")
+}
+
+func TestNewOIDCAuthenticatorLoadsJWKS(t *testing.T) {
+	key := generateKey(t)
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kid": "ec-key", "kty": "EC"},
+			{
+				"kid": "key-1",
+				"kty": "RSA",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			},
+		},
+	}
+
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(jwksFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AUTOCERT_AUTH_JWKS_FILE", jwksFile)
+	t.Setenv("AUTOCERT_AUTH_AUDIENCE", testAudience)
+	t.Setenv("AUTOCERT_AUTH_EMAIL", "")
+
+	authenticator, err := NewOIDCAuthenticator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/cert", nil)
+	r.Header.Set("Authorization", "Bearer "+signToken(t, key, map[string]string{"alg": "RS256", "kid": "key-1"}, validClaims()))
+
+	assertError(t, authenticator.Authenticate(r), "")
+}
+
+// assertError checks that err contains want, or that there is no error when
+// want is empty
+func assertError(t *testing.T, err error, want string) {
+	t.Helper()
+
+	if want == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return
+	}
+
+	if err == nil {
+		t.Errorf("expected error containing %q, got none", want)
+	} else if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,141 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/maxroll/auto-cert/pkg/logging"
+)
+
+type State string
+
+const (
+	Pending   State = "pending"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+)
+
+// maxJobs is the number of finished jobs kept for lookup
+const maxJobs = 100
+
+type Job struct {
+	ID         string      `json:"id"`
+	State      State       `json:"state"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+// Work is executed for every job, the returned result is stored on the job
+type Work func(id string) (interface{}, error)
+
+// Queue runs one job at a time. Triggers while a job is pending or running
+// return that job instead of starting another one
+type Queue struct {
+	work   Work
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	order  []string
+	active *Job
+}
+
+func NewQueue(work Work) *Queue {
+	return &Queue{work: work, jobs: map[string]*Job{}}
+}
+
+// Enqueue starts a new job, or returns the active one. The boolean reports
+// whether a new job was created
+func (q *Queue) Enqueue() (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.active != nil {
+		return *q.active, false
+	}
+
+	job := &Job{
+		ID:        newID(),
+		State:     Pending,
+		CreatedAt: time.Now(),
+	}
+
+	q.jobs[job.ID] = job
+	q.order = append(q.order, job.ID)
+	q.active = job
+
+	// forget the oldest finished jobs
+	for len(q.order) > maxJobs {
+		delete(q.jobs, q.order[0])
+		q.order = q.order[1:]
+	}
+
+	go q.run(job)
+
+	return *job, true
+}
+
+// Get returns a copy of the job
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *job, true
+}
+
+func (q *Queue) run(job *Job) {
+	q.mu.Lock()
+	started := time.Now()
+	job.State = Running
+	job.StartedAt = &started
+	q.mu.Unlock()
+
+	result, err := q.safeWork(job.ID)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.Result = result
+	job.State = Succeeded
+
+	if err != nil {
+		job.State = Failed
+		job.Error = err.Error()
+		logging.Default().With("job_id", job.ID).Errorf("Job %s failed: %s", job.ID, err.Error())
+	}
+
+	q.active = nil
+}
+
+// safeWork turns a panic in the work into a failed job
+func (q *Queue) safeWork(id string) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return q.work(id)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitFinished polls the job until it succeeded or failed
+func waitFinished(t *testing.T, q *Queue, id string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := q.Get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+
+		if job.State == Succeeded || job.State == Failed {
+			return job
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestEnqueueReturnsActiveJob(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+
+	q := NewQueue(func(id string) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return "done", nil
+	})
+
+	first, created := q.Enqueue()
+	if !created {
+		t.Fatal("first Enqueue() did not create a job")
+	}
+
+	<-started
+
+	second, created := q.Enqueue()
+	if created {
+		t.Error("second Enqueue() created a job while one is running")
+	}
+
+	if second.ID != first.ID {
+		t.Errorf("second Enqueue() = %s, want running job %s", second.ID, first.ID)
+	}
+
+	if second.State != Running {
+		t.Errorf("second Enqueue() state = %s, want %s", second.State, Running)
+	}
+
+	close(release)
+
+	job := waitFinished(t, q, first.ID)
+	if job.State != Succeeded || job.Result != "done" {
+		t.Errorf("job = %+v, want succeeded with result", job)
+	}
+
+	third, created := q.Enqueue()
+	if !created || third.ID == first.ID {
+		t.Errorf("Enqueue() after finish = %s, %v, want a new job", third.ID, created)
+	}
+
+	waitFinished(t, q, third.ID)
+}
+
+func TestFailedJobs(t *testing.T) {
+	tests := []struct {
+		name      string
+		work      Work
+		wantError string
+	}{
+		{"error", func(id string) (interface{}, error) { return nil, errors.New("boom") }, "boom"},
+		{"panic", func(id string) (interface{}, error) { panic("boom") }, "panic: boom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(tt.work)
+
+			queued, _ := q.Enqueue()
+
+			job := waitFinished(t, q, queued.ID)
+			if job.State != Failed || job.Error != tt.wantError {
+				t.Errorf("job = %s %q, want %s %q", job.State, job.Error, Failed, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestEvictsOldestFinishedJobs(t *testing.T) {
+	q := NewQueue(func(id string) (interface{}, error) {
+		return nil, nil
+	})
+
+	var ids []string
+	for i := 0; i < maxJobs+5; i++ {
+		job, created := q.Enqueue()
+		if !created {
+			t.Fatalf("Enqueue() %d did not create a job", i)
+		}
+
+		ids = append(ids, job.ID)
+		waitFinished(t, q, job.ID)
+	}
+
+	for i, id := range ids {
+		_, ok := q.Get(id)
+		if want := i >= 5; ok != want {
+			t.Errorf("Get(job %d) found = %v, want %v", i, ok, want)
+		}
+	}
+
+	if len(q.jobs) != maxJobs || len(q.order) != maxJobs {
+		t.Errorf("queue keeps %d jobs in %d order entries, want %d", len(q.jobs), len(q.order), maxJobs)
+	}
+}
@@ -0,0 +1,93 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileLocker locks with an advisory lock on a lock file, for several
+// processes on the same host or sharing a volume. The operating system
+// releases the lock when the holding process exits, so it needs no TTL
+type FileLocker struct {
+	path   string
+	holder string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// fileLock is written to the lock file to show who holds the lock, it is
+// informational only
+type fileLock struct {
+	Holder     string    `json:"holder"`
+	Pid        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+func NewFileLocker(path string, holder string) *FileLocker {
+	return &FileLocker{path: path, holder: holder}
+}
+
+func (l *FileLocker) Name() string {
+	return "file"
+}
+
+func (l *FileLocker) TryLock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the file is never removed, replacing it would let a second process
+	// lock the new file while the first still holds the old one
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open lock file: %v", err)
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	data, err := json.Marshal(fileLock{l.holder, os.Getpid(), time.Now()})
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.WriteAt(data, 0)
+	}
+
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return fmt.Errorf("could not write lock file: %v", err)
+	}
+
+	l.file = f
+
+	return nil
+}
+
+func (l *FileLocker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("lock %s is not held", l.path)
+	}
+
+	f := l.file
+	l.file = nil
+
+	f.Truncate(0)
+
+	if err := unlockFile(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
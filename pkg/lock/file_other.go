@@ -0,0 +1,17 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package lock
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func lockFile(f *os.File) error {
+	return fmt.Errorf("file locking is not supported on %s", runtime.GOOS)
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,42 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package lock
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLocker(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "auto-cert.lock")
+
+	first := NewFileLocker(path, "first")
+	second := NewFileLocker(path, "second")
+
+	if err := first.TryLock(ctx); err != nil {
+		t.Fatalf("first TryLock: %v", err)
+	}
+
+	if err := second.TryLock(ctx); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second TryLock while held = %v, want ErrLocked", err)
+	}
+
+	if err := first.Unlock(ctx); err != nil {
+		t.Fatalf("first Unlock: %v", err)
+	}
+
+	if err := second.TryLock(ctx); err != nil {
+		t.Fatalf("second TryLock after release: %v", err)
+	}
+
+	if err := first.Unlock(ctx); err == nil {
+		t.Errorf("Unlock of a lock not held succeeded")
+	}
+
+	if err := second.Unlock(ctx); err != nil {
+		t.Fatalf("second Unlock: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrLocked = errors.New("lock is held by another holder")
+
+// Locker is a mutual exclusion lock shared between processes. Locks are
+// released by the operating system or expire after a TTL, so a crashed holder
+// can not block others forever
+type Locker interface {
+	// TryLock acquires the lock or returns ErrLocked if it is held
+	TryLock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+	Name() string
+}
+
+// Acquire retries TryLock until the lock is held, ctx is done or the timeout
+// passed
+func Acquire(ctx context.Context, locker Locker, timeout time.Duration, retryInterval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := locker.TryLock(ctx)
+
+		if !errors.Is(err, ErrLocked) {
+			return err
+		}
+
+		if time.Now().Add(retryInterval).After(deadline) {
+			return fmt.Errorf("could not acquire %s lock within %s: %w", locker.Name(), timeout, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// NewHolderID returns a random ID identifying the lock holder, only lowercase
+// letters and digits are used so it is valid as a label value
+func NewHolderID() string {
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,250 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warning
+	Error
+)
+
+// String returns the level as Cloud Logging severity
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warning:
+		return "WARNING"
+	case Error:
+		return "ERROR"
+	}
+
+	return "DEFAULT"
+}
+
+func ParseLevel(level string) (Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return Debug, nil
+	case "info", "":
+		return Info, nil
+	case "warning", "warn":
+		return Warning, nil
+	case "error":
+		return Error, nil
+	}
+
+	return Info, fmt.Errorf("Unknown log level: %s", level)
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes leveled log lines as text or as JSON with a severity field
+// understood by Cloud Logging. Fields added with With are written on every line
+type Logger struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	level  Level
+	json   bool
+	fields []field
+}
+
+var std = New(os.Stderr, "text", Info)
+
+func New(out io.Writer, format string, level Level) *Logger {
+	return &Logger{out: out, mu: &sync.Mutex{}, level: level, json: format == "json"}
+}
+
+// Default returns the process wide logger
+func Default() *Logger {
+	return std
+}
+
+func SetDefault(l *Logger) {
+	std = l
+}
+
+// With returns a logger adding the field to every line
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, 0, len(l.fields)+1)
+
+	for _, f := range l.fields {
+		if f.key != key {
+			fields = append(fields, f)
+		}
+	}
+
+	child := *l
+	child.fields = append(fields, field{key, value})
+
+	return &child
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(Debug, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(Info, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(Warning, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(Error, format, args...)
+}
+
+// Fatalf logs at error level and exits
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(Error, format, args...)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+
+	now := time.Now()
+	message := fmt.Sprintf(format, args...)
+
+	var line []byte
+
+	if l.json {
+		entry := map[string]interface{}{}
+
+		for _, f := range l.fields {
+			entry[f.key] = f.value
+		}
+
+		entry["severity"] = level.String()
+		entry["time"] = now.Format(time.RFC3339Nano)
+		entry["message"] = message
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			data = []byte(fmt.Sprintf(`{"severity":"ERROR","message":%q}`, "could not marshal log entry: "+err.Error()))
+		}
+
+		line = append(data, '\n')
+	} else {
+		var b strings.Builder
+
+		fmt.Fprintf(&b, "%s %-7s %s", now.Format("2006/01/02 15:04:05"), level.String(), message)
+
+		fields := append([]field(nil), l.fields...)
+		sort.SliceStable(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+
+		for _, f := range fields {
+			fmt.Fprintf(&b, " %s=%v", f.key, f.value)
+		}
+
+		b.WriteByte('\n')
+		line = []byte(b.String())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.out.Write(line)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of the context, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+
+	return Default()
+}
+
+type runIDKey struct{}
+
+// WithRunID returns a context carrying the run ID and a logger tagging every
+// line with it
+func WithRunID(ctx context.Context, runID string) context.Context {
+	ctx = context.WithValue(ctx, runIDKey{}, runID)
+
+	return NewContext(ctx, FromContext(ctx).With("run_id", runID))
+}
+
+// RunID returns the run ID of the context, or an empty string
+func RunID(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+
+	return runID
+}
+
+// NewRunID returns a random ID used to correlate the log lines of one run
+func NewRunID() string {
+	b := make([]byte, 8)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// StdAdapter adapts a Logger to the Print/Fatal interface used by libraries
+// such as lego
+type StdAdapter struct {
+	l *Logger
+}
+
+func StdLogger(l *Logger) *StdAdapter {
+	return &StdAdapter{l}
+}
+
+func (s *StdAdapter) Print(args ...interface{}) {
+	s.l.Infof("%s", strings.TrimSuffix(fmt.Sprint(args...), "\n"))
+}
+
+func (s *StdAdapter) Println(args ...interface{}) {
+	s.l.Infof("%s", strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+func (s *StdAdapter) Printf(format string, args ...interface{}) {
+	s.l.Infof("%s", strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+func (s *StdAdapter) Fatal(args ...interface{}) {
+	s.l.Fatalf("%s", fmt.Sprint(args...))
+}
+
+func (s *StdAdapter) Fatalln(args ...interface{}) {
+	s.l.Fatalf("%s", strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+func (s *StdAdapter) Fatalf(format string, args ...interface{}) {
+	s.l.Fatalf(format, args...)
+}
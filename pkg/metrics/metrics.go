@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	certificateExpiry = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "autocert_certificate_expiry_timestamp_seconds",
+		Help: "NotAfter of the stored certificate as unix timestamp.",
+	}, []string{"secret", "hostnames"})
+
+	acmeOrders = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "autocert_acme_orders_total",
+		Help: "ACME certificate orders by type (new, renew).",
+	}, []string{"type"})
+
+	renewals = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "autocert_renewals_total",
+		Help: "Certificates issued or renewed and stored.",
+	}, []string{"secret"})
+
+	failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "autocert_failures_total",
+		Help: "Failed renewal runs.",
+	}, []string{"secret"})
+
+	runnerRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "autocert_runner_runs_total",
+		Help: "Runner executions by result (success, failure).",
+	}, []string{"runner", "result"})
+
+	runnerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "autocert_runner_duration_seconds",
+		Help:    "Duration of runner executions.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"runner"})
+
+	// hostname label currently exported per secret, replaced when it changes
+	expiryLabels   = map[string]string{}
+	expiryLabelsMu sync.Mutex
+)
+
+func SetCertificateExpiry(secret string, hostnames []string, notAfter time.Time) {
+	expiryLabelsMu.Lock()
+	defer expiryLabelsMu.Unlock()
+
+	label := strings.Join(hostnames, ",")
+
+	if previous, ok := expiryLabels[secret]; ok && previous != label {
+		certificateExpiry.DeleteLabelValues(secret, previous)
+	}
+
+	expiryLabels[secret] = label
+	certificateExpiry.WithLabelValues(secret, label).Set(float64(notAfter.Unix()))
+}
+
+// ACMEOrder counts an order, orderType is "new" or "renew"
+func ACMEOrder(orderType string) {
+	acmeOrders.WithLabelValues(orderType).Inc()
+}
+
+func Renewal(secret string) {
+	renewals.WithLabelValues(secret).Inc()
+}
+
+func Failure(secret string) {
+	failures.WithLabelValues(secret).Inc()
+}
+
+func RunnerExecuted(runner string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	runnerRuns.WithLabelValues(runner, result).Inc()
+	runnerDuration.WithLabelValues(runner).Observe(duration.Seconds())
+}
@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/runner"
+)
+
+type Kind string
+
+const (
+	Issued     Kind = "issued"
+	Renewed    Kind = "renewed"
+	Skipped    Kind = "skipped"
+	Deployed   Kind = "deployed"
+	Failed     Kind = "failed"
+	RolledBack Kind = "rolled_back"
+)
+
+// Event describes the outcome of a run
+type Event struct {
+	Kind      Kind            `json:"kind"`
+	Secret    string          `json:"secret"`
+	Hostnames []string        `json:"hostnames"`
+	Time      time.Time       `json:"time"`
+	Error     string          `json:"error,omitempty"`
+	Runners   []runner.Result `json:"runners,omitempty"`
+}
+
+type Notifier interface {
+	Name() string
+	Notify(event Event) error
+}
+
+// NewNotifiers creates the notifiers by name, each notifier reads its own
+// settings from the environment
+func NewNotifiers(names []string) ([]Notifier, error) {
+	var notifiers []Notifier
+
+	for _, name := range names {
+		var notifier Notifier
+		var err error
+
+		switch name {
+		case "webhook":
+			notifier, err = NewWebhookNotifier()
+		case "slack":
+			notifier, err = NewSlackNotifier()
+		case "smtp":
+			notifier, err = NewSMTPNotifier()
+		default:
+			return nil, fmt.Errorf("Unknown notifier: %s", name)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		notifiers = append(notifiers, notifier)
+	}
+
+	return notifiers, nil
+}
+
+// Dispatcher sends events of the enabled kinds to all notifiers
+type Dispatcher struct {
+	notifiers []Notifier
+	kinds     map[Kind]bool
+}
+
+func NewDispatcher(notifiers []Notifier, kinds []string) *Dispatcher {
+	enabled := map[Kind]bool{}
+
+	for _, kind := range kinds {
+		enabled[Kind(strings.TrimSpace(kind))] = true
+	}
+
+	return &Dispatcher{notifiers, enabled}
+}
+
+func (d *Dispatcher) Dispatch(ctx context.Context, event Event) {
+	if d == nil || !d.kinds[event.Kind] {
+		return
+	}
+
+	for _, notifier := range d.notifiers {
+		if err := notifier.Notify(event); err != nil {
+			logging.FromContext(ctx).With("notifier", notifier.Name()).Errorf("Failed to send %s event: %s", event.Kind, err.Error())
+		}
+	}
+}
+
+// Summary renders the event as human readable text
+func (e Event) Summary() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "auto-cert: certificate %s %s for %s", e.Secret, e.Kind, strings.Join(e.Hostnames, ", "))
+
+	if e.Error != "" {
+		fmt.Fprintf(&b, "\nError: %s", e.Error)
+	}
+
+	for _, result := range e.Runners {
+		if !result.Success {
+			fmt.Fprintf(&b, "\n- %s: failed: %s", result.Runner, result.Error)
+		} else if result.Skipped {
+			fmt.Fprintf(&b, "\n- %s: skipped", result.Runner)
+		} else {
+			fmt.Fprintf(&b, "\n- %s: ok", result.Runner)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"fmt"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/go-resty/resty/v2"
+)
+
+// SlackNotifier posts to a Slack compatible incoming webhook
+type SlackNotifier struct {
+	url    string
+	client *resty.Client
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+func NewSlackNotifier() (*SlackNotifier, error) {
+	url := env.GetOrDefaultString("AUTOCERT_SLACK_WEBHOOK_URL", "")
+
+	if url == "" {
+		return nil, fmt.Errorf("env var AUTOCERT_SLACK_WEBHOOK_URL not set")
+	}
+
+	return &SlackNotifier{url, newClient()}, nil
+}
+
+func (n *SlackNotifier) Name() string {
+	return "slack"
+}
+
+func (n *SlackNotifier) Notify(event Event) error {
+	return post(n.client, n.url, slackMessage{event.Summary()})
+}
@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+)
+
+type SMTPNotifier struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func NewSMTPNotifier() (*SMTPNotifier, error) {
+	n := &SMTPNotifier{
+		host:     env.GetOrDefaultString("AUTOCERT_SMTP_HOST", ""),
+		port:     env.GetOrDefaultInt("AUTOCERT_SMTP_PORT", 587),
+		username: env.GetOrDefaultString("AUTOCERT_SMTP_USERNAME", ""),
+		password: env.GetOrDefaultString("AUTOCERT_SMTP_PASSWORD", ""),
+		from:     env.GetOrDefaultString("AUTOCERT_SMTP_FROM", ""),
+	}
+
+	if to := env.GetOrDefaultString("AUTOCERT_SMTP_TO", ""); to != "" {
+		n.to = strings.Split(to, ",")
+	}
+
+	if n.host == "" || n.from == "" || len(n.to) == 0 {
+		return nil, fmt.Errorf("env vars AUTOCERT_SMTP_HOST, AUTOCERT_SMTP_FROM and AUTOCERT_SMTP_TO must be set")
+	}
+
+	return n, nil
+}
+
+func (n *SMTPNotifier) Name() string {
+	return "smtp"
+}
+
+func (n *SMTPNotifier) Notify(event Event) error {
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	subject := fmt.Sprintf("[auto-cert] %s %s", event.Secret, event.Kind)
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		n.from, strings.Join(n.to, ", "), subject, event.Summary())
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", n.host, n.port), auth, n.from, n.to, []byte(message))
+}
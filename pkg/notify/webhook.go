@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/go-resty/resty/v2"
+)
+
+// WebhookNotifier posts the event as JSON
+type WebhookNotifier struct {
+	url    string
+	client *resty.Client
+}
+
+func NewWebhookNotifier() (*WebhookNotifier, error) {
+	url := env.GetOrDefaultString("AUTOCERT_WEBHOOK_URL", "")
+
+	if url == "" {
+		return nil, fmt.Errorf("env var AUTOCERT_WEBHOOK_URL not set")
+	}
+
+	return &WebhookNotifier{url, newClient()}, nil
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *WebhookNotifier) Notify(event Event) error {
+	return post(n.client, n.url, event)
+}
+
+func newClient() *resty.Client {
+	client := resty.New()
+	client.SetTimeout(10 * time.Second)
+	client.SetHeader("Content-Type", "application/json")
+
+	return client
+}
+
+func post(client *resty.Client, url string, body interface{}) error {
+	resp, err := client.R().SetBody(body).Post(url)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.IsError() {
+		return fmt.Errorf("%s returned %d: %s", url, resp.StatusCode(), string(resp.Body()))
+	}
+
+	return nil
+}
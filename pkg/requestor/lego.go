@@ -1,12 +1,12 @@
 package requestor
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"errors"
-	"log"
 
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
@@ -14,6 +14,7 @@ import (
 	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
+	"github.com/maxroll/auto-cert/pkg/logging"
 )
 
 type AcmeUser struct {
@@ -107,7 +108,7 @@ func (r *Requestor) GenerateUserKeys(email string) *AcmeUser {
 
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		log.Fatal(err)
+		logging.Default().Fatalf("Could not generate user key: %v", err)
 	}
 
 	user := CreateUser(email, privateKey, false)
@@ -115,7 +116,7 @@ func (r *Requestor) GenerateUserKeys(email string) *AcmeUser {
 	return user
 }
 
-func (r *Requestor) RenewCertificate(user *AcmeUser, hostnames []string, privateKey []byte) (*Certificate, error) {
+func (r *Requestor) RenewCertificate(ctx context.Context, user *AcmeUser, hostnames []string, privateKey []byte) (*Certificate, error) {
 
 	parsed, err := certcrypto.ParsePEMPrivateKey(privateKey)
 	if err != nil {
@@ -128,9 +129,11 @@ func (r *Requestor) RenewCertificate(user *AcmeUser, hostnames []string, private
 		PrivateKey: parsed,
 	}
 
+	logging.FromContext(ctx).Infof("Requesting certificate for %s with existing private key", hostnames)
+
 	certificates, err := r.client.Certificate.Obtain(request)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	return &Certificate{
@@ -140,12 +143,14 @@ func (r *Requestor) RenewCertificate(user *AcmeUser, hostnames []string, private
 
 }
 
-func (r *Requestor) GenerateCertificate(user *AcmeUser, hostnames []string) (*Certificate, error) {
+func (r *Requestor) GenerateCertificate(ctx context.Context, user *AcmeUser, hostnames []string) (*Certificate, error) {
 
 	request := certificate.ObtainRequest{
 		Domains: hostnames,
 		Bundle:  true,
 	}
+
+	logging.FromContext(ctx).Infof("Requesting certificate for %s with new private key", hostnames)
 	certificates, err := r.client.Certificate.Obtain(request)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,159 @@
+package requestor
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	ErrInvalidKey    = errors.New("private key could not be parsed")
+	ErrKeyMismatch   = errors.New("private key does not match certificate")
+	ErrInvalidChain  = errors.New("certificate chain does not verify")
+	ErrMissingSANs   = errors.New("certificate does not cover all hostnames")
+	ErrRevoked       = errors.New("certificate has been revoked")
+	ErrInvalidBundle = errors.New("certificate bundle could not be parsed")
+)
+
+// ValidateOptions controls the checks of ValidateCertificate
+type ValidateOptions struct {
+	// Roots to verify the chain against, nil uses the system pool
+	Roots *x509.CertPool
+	// SkipChain disables the chain check, for CAs like the Let's Encrypt
+	// staging environment whose roots are not trusted anywhere
+	SkipChain bool
+}
+
+// ValidateCertificate checks that the stored certificate can be safely
+// deployed: the private key belongs to the certificate, the chain verifies
+// unless skipped, all hostnames are covered and the certificate has not been
+// revoked.
+func ValidateCertificate(ctx context.Context, cert *Certificate, hostnames []string, opts ValidateOptions) error {
+	certificates, err := certcrypto.ParsePEMBundle(cert.Certificate)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+
+	leaf := certificates[0]
+
+	privateKey, err := certcrypto.ParsePEMPrivateKey(cert.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("%w: unsupported key type %T", ErrInvalidKey, privateKey)
+	}
+
+	publicKey, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(leaf.PublicKey) {
+		return ErrKeyMismatch
+	}
+
+	if !opts.SkipChain {
+		intermediates := x509.NewCertPool()
+		for _, c := range certificates[1:] {
+			intermediates.AddCert(c)
+		}
+
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         opts.Roots,
+			Intermediates: intermediates,
+			CurrentTime:   time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidChain, err)
+		}
+	}
+
+	for _, hostname := range hostnames {
+		if !hostnameCovered(leaf.DNSNames, hostname) {
+			return fmt.Errorf("%w: %s missing", ErrMissingSANs, hostname)
+		}
+	}
+
+	if len(certificates) < 2 {
+		return nil
+	}
+
+	ocspResponse, err := fetchOCSP(ctx, leaf, certificates[1])
+	if err != nil {
+		// OCSP being unavailable is not a reason to reissue
+		logging.FromContext(ctx).Warnf("Could not check OCSP status: %v", err)
+		return nil
+	}
+
+	if ocspResponse != nil && ocspResponse.Status == ocsp.Revoked {
+		return fmt.Errorf("%w at %s", ErrRevoked, ocspResponse.RevokedAt)
+	}
+
+	return nil
+}
+
+// fetchOCSP queries the responder listed in the certificate, a nil response
+// is returned when the certificate has no OCSP server.
+func fetchOCSP(ctx context.Context, leaf *x509.Certificate, issuer *x509.Certificate) (*ocsp.Response, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil
+	}
+
+	request, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/ocsp-request")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	resp, err := client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return ocsp.ParseResponseForCert(body, leaf, issuer)
+}
+
+func hostnameCovered(sans []string, hostname string) bool {
+	for _, san := range sans {
+		if strings.EqualFold(san, hostname) {
+			return true
+		}
+
+		// a wildcard SAN covers exactly one label
+		if strings.HasPrefix(san, "*.") {
+			idx := strings.Index(hostname, ".")
+			if idx > 0 && strings.EqualFold(san[1:], hostname[idx:]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,151 @@
+package requestor
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// issue creates a certificate signed by parent, or a self-signed CA when
+// parent is nil
+func issue(t *testing.T, parent *testCert, dnsNames []string, notAfter time.Time) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "test"},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	signerCert, signerKey := template, crypto.Signer(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, key.Public(), signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert: cert, key: key}
+}
+
+func bundle(key crypto.Signer, certs ...*testCert) *Certificate {
+	var pemCerts []byte
+	for _, c := range certs {
+		pemCerts = append(pemCerts, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})...)
+	}
+
+	return &Certificate{Certificate: pemCerts, PrivateKey: GetPrivateKeyBytes(key)}
+}
+
+func TestValidateCertificate(t *testing.T) {
+	valid := time.Now().Add(24 * time.Hour)
+
+	root := issue(t, nil, nil, valid)
+	other := issue(t, nil, nil, valid)
+
+	leaf := issue(t, root, []string{"example.com", "*.example.com"}, valid)
+	expired := issue(t, root, []string{"example.com"}, time.Now().Add(-time.Hour))
+	untrusted := issue(t, other, []string{"example.com"}, valid)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	tests := []struct {
+		name      string
+		cert      *Certificate
+		hostnames []string
+		skipChain bool
+		want      error
+	}{
+		{"valid", bundle(leaf.key, leaf, root), []string{"example.com", "www.example.com"}, false, nil},
+		{"leaf only", bundle(leaf.key, leaf), []string{"example.com"}, false, nil},
+		{"wildcard one label", bundle(leaf.key, leaf, root), []string{"a.b.example.com"}, false, ErrMissingSANs},
+		{"missing hostname", bundle(leaf.key, leaf, root), []string{"example.org"}, false, ErrMissingSANs},
+		{"expired", bundle(expired.key, expired, root), []string{"example.com"}, false, ErrInvalidChain},
+		{"untrusted chain", bundle(untrusted.key, untrusted, other), []string{"example.com"}, false, ErrInvalidChain},
+		{"untrusted chain skipped", bundle(untrusted.key, untrusted, other), []string{"example.com"}, true, nil},
+		{"key mismatch", bundle(other.key, leaf, root), []string{"example.com"}, false, ErrKeyMismatch},
+		{"invalid bundle", &Certificate{Certificate: []byte("garbage"), PrivateKey: GetPrivateKeyBytes(leaf.key)}, nil, false, ErrInvalidBundle},
+		{"invalid key", &Certificate{Certificate: bundle(leaf.key, leaf).Certificate, PrivateKey: []byte("garbage")}, nil, false, ErrInvalidKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCertificate(context.Background(), tt.cert, tt.hostnames, ValidateOptions{Roots: roots, SkipChain: tt.skipChain})
+
+			if tt.want == nil && err != nil {
+				t.Fatalf("ValidateCertificate() = %v, want nil", err)
+			}
+
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ValidateCertificate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostnameCovered(t *testing.T) {
+	sans := []string{"example.com", "*.example.com", "Mixed.example.org"}
+
+	tests := []struct {
+		hostname string
+		want     bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"www.example.com", true},
+		{"*.example.com", true},
+		{"a.b.example.com", false},
+		{"example.org", false},
+		{"mixed.example.org", true},
+		{"www.mixed.example.org", false},
+		{".example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			if got := hostnameCovered(sans, tt.hostname); got != tt.want {
+				t.Errorf("hostnameCovered(%q) = %v, want %v", tt.hostname, got, tt.want)
+			}
+		})
+	}
+}
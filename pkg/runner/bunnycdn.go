@@ -3,10 +3,8 @@ package runner
 import (
 	"context"
 	"fmt"
-	"log"
-	"sync"
 
-	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/maxroll/auto-cert/pkg/logging"
 	"github.com/maxroll/auto-cert/pkg/requestor"
 	bunny "github.com/simplesurance/bunny-go"
 )
@@ -19,32 +17,133 @@ type BunnyConfig struct {
 type BunnyCDNRunner struct {
 	config *BunnyConfig
 	*bunny.Client
-	context.Context
-	*sync.WaitGroup
 }
 
-func NewBunnyCDNRunner() *BunnyCDNRunner {
+func init() {
+	Register(Factory{
+		Name:        "bunnycdn",
+		Description: "Adds the certificate to the hostnames of a BunnyCDN pull zone",
+		Settings: []Setting{
+			{Env: "BUNNYCDN_PULL_ZONE_ID", Description: "ID of the pull zone", Required: true},
+			{Env: "BUNNYCDN_API_KEY", Description: "Account API key", Required: true},
+		},
+		New: func(settings Settings) (Runner, error) {
+			return NewBunnyCDNRunner(settings)
+		},
+	})
+}
+
+func NewBunnyCDNRunner(settings Settings) (*BunnyCDNRunner, error) {
+	pullZoneId, err := settings.Int("BUNNYCDN_PULL_ZONE_ID")
+	if err != nil {
+		return nil, err
+	}
+
 	config := &BunnyConfig{
-		PullZoneId: int64(env.GetOrDefaultInt("BUNNYCDN_PULL_ZONE_ID", 0)),
-		ApiKey:     env.GetOrDefaultString("BUNNYCDN_API_KEY", ""),
+		PullZoneId: int64(pullZoneId),
+		ApiKey:     settings.String("BUNNYCDN_API_KEY"),
 	}
 
 	client := bunny.NewClient(config.ApiKey)
 
-	return &BunnyCDNRunner{config, client, context.Background(), nil}
+	return &BunnyCDNRunner{config, client}, nil
+}
+
+func (r *BunnyCDNRunner) Name() string {
+	return "bunnycdn"
+}
+
+func (r *BunnyCDNRunner) Target() string {
+	return fmt.Sprintf("pullzone/%d", r.config.PullZoneId)
 }
 
-func (r *BunnyCDNRunner) Exec(hostnames []string, certificate *requestor.Certificate) error {
-	log.Printf("[BunnyCDN Runner] Updating certificate in BunnyCDN")
+func (r *BunnyCDNRunner) Exec(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error) {
+	log := logging.FromContext(ctx)
+
+	log.Infof("Updating certificate in BunnyCDN")
 
 	if certificate == nil {
-		return fmt.Errorf("No certificate available")
+		return nil, fmt.Errorf("No certificate available")
+	}
+
+	if err := r.checkHostnames(ctx, hostnames); err != nil {
+		return nil, err
 	}
 
+	var changes []string
+
+	for _, hostname := range hostnames {
+
+		log.Infof("Adding custom certificate for hostname %s", hostname)
+
+		cert := &bunny.PullZoneAddCustomCertificateOptions{
+			Hostname:       hostname,
+			Certificate:    certificate.Certificate,
+			CertificateKey: certificate.PrivateKey,
+		}
+
+		err := r.Client.PullZone.AddCustomCertificate(ctx, r.config.PullZoneId, cert)
+
+		if err != nil {
+			return changes, err
+		}
+
+		changes = append(changes, fmt.Sprintf("added custom certificate for %s to pull zone %d", hostname, r.config.PullZoneId))
+	}
+
+	log.Infof("BunnyCDN runner finished!")
+
+	return changes, nil
+
+}
+
+func (r *BunnyCDNRunner) Plan(ctx context.Context, hostnames []string, certificate *requestor.Certificate) (string, error) {
+	if err := r.checkHostnames(ctx, hostnames); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("add custom certificate to pull zone %d for hostnames %s", r.config.PullZoneId, hostnames), nil
+}
+
+// Verify checks that BunnyCDN has a certificate for every hostname. BunnyCDN
+// does not expose the certificate itself, the served certificate is compared
+// by the verify command when AUTOCERT_VERIFY_TLS is enabled
+func (r *BunnyCDNRunner) Verify(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error) {
+	pz, err := r.Client.PullZone.Get(ctx, r.config.PullZoneId)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get pull zone: %w", err)
+	}
+
+	var drift []string
+
+	for _, hostname := range hostnames {
+		var bunnyHostname *bunny.Hostname
+
+		for _, h := range pz.Hostnames {
+			if h.Value != nil && *h.Value == hostname {
+				bunnyHostname = h
+			}
+		}
+
+		if bunnyHostname == nil {
+			drift = append(drift, fmt.Sprintf("hostname %s missing in pull zone %d", hostname, r.config.PullZoneId))
+			continue
+		}
+
+		if bunnyHostname.HasCertificate == nil || !*bunnyHostname.HasCertificate {
+			drift = append(drift, fmt.Sprintf("hostname %s has no certificate in pull zone %d", hostname, r.config.PullZoneId))
+		}
+	}
+
+	return drift, nil
+}
+
+// checkHostnames verifies all hostnames are configured on the pull zone
+func (r *BunnyCDNRunner) checkHostnames(ctx context.Context, hostnames []string) error {
 	// check if the pull zone exists
-	pz, err := r.Client.PullZone.Get(context.Background(), r.config.PullZoneId)
+	pz, err := r.Client.PullZone.Get(ctx, r.config.PullZoneId)
 	if err != nil {
-		return fmt.Errorf("Could not get pull zone: %v", err.Error())
+		return fmt.Errorf("Could not get pull zone: %w", err)
 	}
 
 	for _, hostname := range hostnames {
@@ -59,24 +158,7 @@ func (r *BunnyCDNRunner) Exec(hostnames []string, certificate *requestor.Certifi
 		if !exists {
 			return fmt.Errorf("Hostname %s missing, add hostname first", hostname)
 		}
-
-		log.Printf("[BunnyCDN Runner] Adding custom certificate for hostname %s", hostname)
-
-		cert := &bunny.PullZoneAddCustomCertificateOptions{
-			Hostname:       hostname,
-			Certificate:    certificate.Certificate,
-			CertificateKey: certificate.PrivateKey,
-		}
-
-		err = r.Client.PullZone.AddCustomCertificate(r.Context, r.config.PullZoneId, cert)
-
-		if err != nil {
-			return err
-		}
 	}
 
-	log.Println("[BunnyCDN Runner] BunnyCDN runner finished!")
-
 	return nil
-
 }
@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/requestor"
+	"github.com/maxroll/auto-cert/pkg/util"
+)
+
+type CloudflareConfig struct {
+	ZoneId       string
+	ApiToken     string
+	BundleMethod string
+}
+
+type CloudflareRunner struct {
+	config *CloudflareConfig
+	*cloudflare.API
+}
+
+func init() {
+	Register(Factory{
+		Name:        "cloudflare",
+		Description: "Creates or updates a custom certificate in a Cloudflare zone (Business and Enterprise plans)",
+		Settings: []Setting{
+			{Env: "CLOUDFLARE_ZONE_ID", Description: "ID of the zone", Required: true},
+			{Env: "CLOUDFLARE_API_TOKEN", Description: "API token allowed to edit SSL and certificates of the zone", Required: true},
+			{Env: "CLOUDFLARE_BUNDLE_METHOD", Description: "How Cloudflare builds the chain: ubiquitous, optimal or force", Default: "force"},
+		},
+		New: func(settings Settings) (Runner, error) {
+			return NewCloudflareRunner(settings)
+		},
+	})
+}
+
+func NewCloudflareRunner(settings Settings) (*CloudflareRunner, error) {
+	config := &CloudflareConfig{
+		ZoneId:       settings.String("CLOUDFLARE_ZONE_ID"),
+		ApiToken:     settings.String("CLOUDFLARE_API_TOKEN"),
+		BundleMethod: settings.String("CLOUDFLARE_BUNDLE_METHOD"),
+	}
+
+	api, err := cloudflare.NewWithAPIToken(config.ApiToken)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not create Cloudflare client: %w", err)
+	}
+
+	return &CloudflareRunner{config, api}, nil
+}
+
+func (r *CloudflareRunner) Name() string {
+	return "cloudflare"
+}
+
+func (r *CloudflareRunner) Target() string {
+	return fmt.Sprintf("zone/%s", r.config.ZoneId)
+}
+
+func (r *CloudflareRunner) Exec(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error) {
+	log := logging.FromContext(ctx)
+
+	log.Infof("Updating custom certificate in Cloudflare")
+
+	if certificate == nil {
+		return nil, fmt.Errorf("No certificate available")
+	}
+
+	existing, err := r.findCertificate(ctx, certificateSANs(hostnames, certificate))
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := cloudflare.ZoneCustomSSLOptions{
+		Certificate:  string(certificate.Certificate),
+		PrivateKey:   string(certificate.PrivateKey),
+		BundleMethod: r.config.BundleMethod,
+	}
+
+	var change string
+
+	if existing != nil {
+		log.Infof("Custom certificate for these hosts already exists, updating...")
+
+		if _, err := r.API.UpdateSSL(ctx, r.config.ZoneId, existing.ID, options); err != nil {
+			return nil, fmt.Errorf("[Cloudflare Runner] Failed to update custom certificate %s: %w", existing.ID, err)
+		}
+
+		change = fmt.Sprintf("updated custom certificate %s in zone %s", existing.ID, r.config.ZoneId)
+
+		log.Infof("Custom certificate updated")
+
+	} else {
+
+		created, err := r.API.CreateSSL(ctx, r.config.ZoneId, options)
+
+		if err != nil {
+			return nil, fmt.Errorf("[Cloudflare Runner] Failed to add custom certificate to zone: %w", err)
+		}
+
+		change = fmt.Sprintf("created custom certificate %s for %s in zone %s", created.ID, created.Hosts, r.config.ZoneId)
+
+		log.Infof("Custom certificate created")
+	}
+
+	log.Infof("Cloudflare runner finished!")
+
+	return []string{change}, nil
+}
+
+func (r *CloudflareRunner) Plan(ctx context.Context, hostnames []string, certificate *requestor.Certificate) (string, error) {
+	existing, err := r.findCertificate(ctx, certificateSANs(hostnames, certificate))
+
+	if err != nil {
+		return "", err
+	}
+
+	if existing != nil {
+		return fmt.Sprintf("update custom certificate %s in zone %s", existing.ID, r.config.ZoneId), nil
+	}
+
+	return fmt.Sprintf("create custom certificate for %s in zone %s", hostnames, r.config.ZoneId), nil
+}
+
+// Verify compares the expiration date of the custom certificate in the zone
+// with the stored certificate
+func (r *CloudflareRunner) Verify(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error) {
+	leaf, err := certcrypto.ParsePEMCertificate(certificate.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := r.findCertificate(ctx, leaf.DNSNames)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		return []string{fmt.Sprintf("no custom certificate for %s in zone %s", leaf.DNSNames, r.config.ZoneId)}, nil
+	}
+
+	if !existing.ExpiresOn.Equal(leaf.NotAfter) {
+		return []string{fmt.Sprintf("zone %s serves custom certificate %s expiring %s, stored certificate expires %s", r.config.ZoneId, existing.ID, existing.ExpiresOn, leaf.NotAfter)}, nil
+	}
+
+	return nil, nil
+}
+
+// findCertificate returns the custom certificate covering exactly the
+// hostnames, or nil if there is none
+func (r *CloudflareRunner) findCertificate(ctx context.Context, hostnames []string) (*cloudflare.ZoneCustomSSL, error) {
+	certs, err := r.API.ListSSL(ctx, r.config.ZoneId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list custom certificates for zone %s: %w", r.config.ZoneId, err)
+	}
+
+	for _, cert := range certs {
+		if util.StringSlicesEqual(cert.Hosts, hostnames) {
+			return &cert, nil
+		}
+	}
+
+	return nil, nil
+}
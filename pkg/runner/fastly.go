@@ -0,0 +1,231 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/requestor"
+	"github.com/maxroll/auto-cert/pkg/util"
+)
+
+type FastlyConfig struct {
+	ApiToken string
+	// Name is given to uploaded certificates and private keys
+	Name string
+}
+
+type FastlyRunner struct {
+	config *FastlyConfig
+	*FastlyAPI
+}
+
+func init() {
+	Register(Factory{
+		Name:        "fastly",
+		Description: "Uploads the private key, creates or updates the certificate and activates it for the hostnames with Fastly Custom TLS",
+		Settings: []Setting{
+			{Env: "FASTLY_API_TOKEN", Description: "API token with TLS management permission", Required: true},
+			{Env: "FASTLY_CERTIFICATE_NAME", Description: "Name of the uploaded certificates and keys", Default: "auto-cert"},
+		},
+		New: func(settings Settings) (Runner, error) {
+			return NewFastlyRunner(settings), nil
+		},
+	})
+}
+
+func NewFastlyRunner(settings Settings) *FastlyRunner {
+	config := &FastlyConfig{
+		ApiToken: settings.String("FASTLY_API_TOKEN"),
+		Name:     settings.String("FASTLY_CERTIFICATE_NAME"),
+	}
+
+	return &FastlyRunner{config, newFastlyAPI(config)}
+}
+
+func (r *FastlyRunner) Name() string {
+	return "fastly"
+}
+
+func (r *FastlyRunner) Target() string {
+	return fmt.Sprintf("tls/%s", r.config.Name)
+}
+
+func (r *FastlyRunner) Exec(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error) {
+	log := logging.FromContext(ctx)
+
+	log.Infof("Updating certificate in Fastly")
+
+	if certificate == nil {
+		return nil, fmt.Errorf("No certificate available")
+	}
+
+	var changes []string
+
+	// Fastly only accepts certificates whose private key was uploaded first
+	uploaded, err := r.FastlyAPI.UploadPrivateKey(ctx, certificate)
+
+	if err != nil {
+		return nil, fmt.Errorf("[Fastly Runner] Failed to upload private key: %w", err)
+	}
+
+	if uploaded {
+		changes = append(changes, "uploaded private key")
+		log.Infof("Private key uploaded")
+	}
+
+	existing, err := r.findCertificate(ctx, certificateSANs(hostnames, certificate))
+
+	if err != nil {
+		return changes, err
+	}
+
+	if existing != nil {
+		log.Infof("Cert for these hostnames already exists, updating...")
+
+		err = r.FastlyAPI.UpdateCertificate(ctx, existing.ID, certificate)
+
+		if err != nil {
+			return changes, fmt.Errorf("[Fastly Runner] Failed to update certificate %s: %w", existing.ID, err)
+		}
+
+		changes = append(changes, fmt.Sprintf("updated certificate %s", existing.ID))
+
+		log.Infof("Certificate updated")
+
+	} else {
+
+		existing, err = r.FastlyAPI.AddCertificate(ctx, certificate)
+
+		if err != nil {
+			return changes, fmt.Errorf("[Fastly Runner] Failed to add certificate: %w", err)
+		}
+
+		changes = append(changes, fmt.Sprintf("created certificate %s for %s", existing.ID, hostnames))
+
+		log.Infof("Certificate created")
+	}
+
+	// an uploaded certificate is only served once it is activated
+	activated, err := r.activate(ctx, existing.ID, hostnames)
+	changes = append(changes, activated...)
+
+	if err != nil {
+		return changes, err
+	}
+
+	log.Infof("Fastly runner finished!")
+
+	return changes, nil
+}
+
+func (r *FastlyRunner) Plan(ctx context.Context, hostnames []string, certificate *requestor.Certificate) (string, error) {
+	existing, err := r.findCertificate(ctx, certificateSANs(hostnames, certificate))
+
+	if err != nil {
+		return "", err
+	}
+
+	if existing != nil {
+		return fmt.Sprintf("upload the private key if needed, update certificate %s and activate it for %s", existing.ID, hostnames), nil
+	}
+
+	return fmt.Sprintf("upload the private key if needed, create certificate and activate it for %s", hostnames), nil
+}
+
+// Verify compares serial number and expiration of the certificate at Fastly
+// with the stored one and checks it is activated for every hostname
+func (r *FastlyRunner) Verify(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error) {
+	leaf, err := certcrypto.ParsePEMCertificate(certificate.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := r.findCertificate(ctx, leaf.DNSNames)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing == nil {
+		return []string{fmt.Sprintf("no certificate for %s at Fastly", leaf.DNSNames)}, nil
+	}
+
+	serial := strings.ToLower(existing.Attributes.SerialNumber)
+	sameSerial := serial == leaf.SerialNumber.String() || serial == leaf.SerialNumber.Text(16)
+
+	notAfter, err := time.Parse(time.RFC3339, existing.Attributes.NotAfter)
+	if err != nil || !notAfter.Equal(leaf.NotAfter) || !sameSerial {
+		return []string{fmt.Sprintf("Fastly serves certificate %s with serial %s expiring %s, stored certificate has serial %s and expires %s", existing.ID, existing.Attributes.SerialNumber, existing.Attributes.NotAfter, leaf.SerialNumber.String(), leaf.NotAfter)}, nil
+	}
+
+	var drift []string
+
+	for _, hostname := range hostnames {
+		activation, err := r.FastlyAPI.FindActivation(ctx, hostname)
+		if err != nil {
+			return drift, err
+		}
+
+		if activation == nil {
+			drift = append(drift, fmt.Sprintf("certificate %s is not activated for %s", existing.ID, hostname))
+		} else if certId := activation.Relationships.TLSCertificate.Data.ID; certId != existing.ID {
+			drift = append(drift, fmt.Sprintf("%s is activated with certificate %s instead of %s", hostname, certId, existing.ID))
+		}
+	}
+
+	return drift, nil
+}
+
+// activate makes Fastly serve the certificate for every hostname: hostnames
+// without an activation get one, activations of another certificate are
+// switched over
+func (r *FastlyRunner) activate(ctx context.Context, certId string, hostnames []string) ([]string, error) {
+	var changes []string
+
+	for _, hostname := range hostnames {
+		activation, err := r.FastlyAPI.FindActivation(ctx, hostname)
+		if err != nil {
+			return changes, err
+		}
+
+		if activation == nil {
+			if err := r.FastlyAPI.AddActivation(ctx, certId, hostname); err != nil {
+				return changes, fmt.Errorf("[Fastly Runner] Failed to activate certificate %s for %s: %w", certId, hostname, err)
+			}
+
+			changes = append(changes, fmt.Sprintf("activated certificate %s for %s", certId, hostname))
+			continue
+		}
+
+		if previous := activation.Relationships.TLSCertificate.Data.ID; previous != certId {
+			if err := r.FastlyAPI.UpdateActivation(ctx, activation.ID, certId); err != nil {
+				return changes, fmt.Errorf("[Fastly Runner] Failed to switch %s to certificate %s: %w", hostname, certId, err)
+			}
+
+			changes = append(changes, fmt.Sprintf("switched %s from certificate %s to %s", hostname, previous, certId))
+		}
+	}
+
+	return changes, nil
+}
+
+// findCertificate returns the certificate whose SANs equal the hostnames, or
+// nil if there is none
+func (r *FastlyRunner) findCertificate(ctx context.Context, hostnames []string) (*FastlyCertificate, error) {
+	certs, err := r.FastlyAPI.ListCertificates(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cert := range certs {
+		if util.StringSlicesEqual(cert.Domains(), hostnames) {
+			return &cert, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,377 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-resty/resty/v2"
+	"github.com/maxroll/auto-cert/pkg/requestor"
+	"github.com/maxroll/auto-cert/pkg/util"
+)
+
+const (
+	fastlyApiUrl   = "https://api.fastly.com"
+	fastlyPageSize = 100
+)
+
+type FastlyRelationship struct {
+	Data []FastlyResourceIdentifier `json:"data"`
+}
+
+type FastlyResourceIdentifier struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// FastlyToOne is a relationship to a single resource
+type FastlyToOne struct {
+	Data FastlyResourceIdentifier `json:"data"`
+}
+
+type FastlyCertificate struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Name         string `json:"name"`
+		NotAfter     string `json:"not_after"`
+		SerialNumber string `json:"serial_number"`
+	} `json:"attributes"`
+	Relationships struct {
+		TLSDomains FastlyRelationship `json:"tls_domains"`
+	} `json:"relationships"`
+}
+
+// Domains returns the SANs of the certificate
+func (c FastlyCertificate) Domains() []string {
+	domains := make([]string, len(c.Relationships.TLSDomains.Data))
+
+	for i, domain := range c.Relationships.TLSDomains.Data {
+		domains[i] = domain.ID
+	}
+
+	return domains
+}
+
+type FastlyPrivateKey struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Name          string `json:"name"`
+		PublicKeySha1 string `json:"public_key_sha1"`
+	} `json:"attributes"`
+}
+
+// FastlyActivation makes Fastly serve a certificate for a domain
+type FastlyActivation struct {
+	ID            string `json:"id"`
+	Relationships struct {
+		TLSCertificate FastlyToOne `json:"tls_certificate"`
+		TLSDomain      FastlyToOne `json:"tls_domain"`
+	} `json:"relationships"`
+}
+
+type fastlyActivationList struct {
+	Data []FastlyActivation `json:"data"`
+}
+
+type fastlyCertificateList struct {
+	Data []FastlyCertificate `json:"data"`
+}
+
+type fastlyPrivateKeyList struct {
+	Data []FastlyPrivateKey `json:"data"`
+}
+
+type fastlyCertificateResult struct {
+	Data FastlyCertificate `json:"data"`
+}
+
+type FastlyAPI struct {
+	config *FastlyConfig
+	client *resty.Client
+}
+
+func newFastlyAPI(config *FastlyConfig) *FastlyAPI {
+	client := resty.New()
+
+	client.SetHeader("Accept", "application/vnd.api+json")
+	client.SetHeader("Content-Type", "application/vnd.api+json")
+	client.SetHeader("Fastly-Key", config.ApiToken)
+
+	return &FastlyAPI{config, client}
+}
+
+func (f *FastlyAPI) ListCertificates(ctx context.Context) ([]FastlyCertificate, error) {
+	var certificates []FastlyCertificate
+
+	for page := 1; ; page++ {
+		resp, err := f.client.R().
+			SetContext(ctx).
+			SetQueryParams(map[string]string{
+				"include":      "tls_domains",
+				"page[number]": strconv.Itoa(page),
+				"page[size]":   strconv.Itoa(fastlyPageSize),
+			}).
+			SetResult(&fastlyCertificateList{}).
+			Get(fmt.Sprintf("%s/tls/certificates", fastlyApiUrl))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode() != 200 {
+			return nil, fmt.Errorf("Failed to list TLS certificates: %w", &StatusError{resp.StatusCode(), string(resp.Body())})
+		}
+
+		list := resp.Result().(*fastlyCertificateList)
+		certificates = append(certificates, list.Data...)
+
+		if len(list.Data) < fastlyPageSize {
+			return certificates, nil
+		}
+	}
+}
+
+// UploadPrivateKey uploads the private key of the certificate unless Fastly
+// already has it, which is the case when a renewal kept the key
+func (f *FastlyAPI) UploadPrivateKey(ctx context.Context, certificate *requestor.Certificate) (bool, error) {
+	leaf, err := certcrypto.ParsePEMCertificate(certificate.Certificate)
+	if err != nil {
+		return false, err
+	}
+
+	publicKeySha1, err := publicKeySha1(leaf)
+	if err != nil {
+		return false, err
+	}
+
+	keys, err := f.listPrivateKeys(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, key := range keys {
+		if key.Attributes.PublicKeySha1 == publicKeySha1 {
+			return false, nil
+		}
+	}
+
+	resp, err := f.client.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"data": map[string]interface{}{
+				"type": "tls_private_key",
+				"attributes": map[string]string{
+					"key":  string(certificate.PrivateKey),
+					"name": fmt.Sprintf("%s-%s", f.config.Name, leaf.SerialNumber.Text(16)),
+				},
+			},
+		}).
+		Post(fmt.Sprintf("%s/tls/private_keys", fastlyApiUrl))
+
+	if err != nil {
+		return false, err
+	}
+
+	if resp.StatusCode() != 201 {
+		return false, &StatusError{resp.StatusCode(), string(resp.Body())}
+	}
+
+	return true, nil
+}
+
+func (f *FastlyAPI) listPrivateKeys(ctx context.Context) ([]FastlyPrivateKey, error) {
+	var keys []FastlyPrivateKey
+
+	for page := 1; ; page++ {
+		resp, err := f.client.R().
+			SetContext(ctx).
+			SetQueryParams(map[string]string{
+				"page[number]": strconv.Itoa(page),
+				"page[size]":   strconv.Itoa(fastlyPageSize),
+			}).
+			SetResult(&fastlyPrivateKeyList{}).
+			Get(fmt.Sprintf("%s/tls/private_keys", fastlyApiUrl))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode() != 200 {
+			return nil, fmt.Errorf("Failed to list TLS private keys: %w", &StatusError{resp.StatusCode(), string(resp.Body())})
+		}
+
+		list := resp.Result().(*fastlyPrivateKeyList)
+		keys = append(keys, list.Data...)
+
+		if len(list.Data) < fastlyPageSize {
+			return keys, nil
+		}
+	}
+}
+
+func (f *FastlyAPI) AddCertificate(ctx context.Context, certificate *requestor.Certificate) (*FastlyCertificate, error) {
+	attributes, err := f.certificateAttributes(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"data": map[string]interface{}{
+				"type":       "tls_certificate",
+				"attributes": attributes,
+			},
+		}).
+		SetResult(&fastlyCertificateResult{}).
+		Post(fmt.Sprintf("%s/tls/certificates", fastlyApiUrl))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() != 201 {
+		return nil, &StatusError{resp.StatusCode(), string(resp.Body())}
+	}
+
+	return &resp.Result().(*fastlyCertificateResult).Data, nil
+}
+
+// UpdateCertificate replaces the certificate, Fastly requires the new one to
+// have the same SANs
+func (f *FastlyAPI) UpdateCertificate(ctx context.Context, certId string, certificate *requestor.Certificate) error {
+	attributes, err := f.certificateAttributes(certificate)
+	if err != nil {
+		return err
+	}
+
+	resp, err := f.client.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":         certId,
+				"type":       "tls_certificate",
+				"attributes": attributes,
+			},
+		}).
+		Patch(fmt.Sprintf("%s/tls/certificates/%s", fastlyApiUrl, certId))
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != 200 {
+		return &StatusError{resp.StatusCode(), string(resp.Body())}
+	}
+
+	return nil
+}
+
+// certificateAttributes sends the leaf as certificate and the rest of the
+// chain as intermediates, as Fastly expects them
+func (f *FastlyAPI) certificateAttributes(certificate *requestor.Certificate) (map[string]string, error) {
+	bundle, err := util.SplitCerts(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"cert_blob":          string(bundle.Certificate),
+		"intermediates_blob": string(bundle.CaBundle),
+		"name":               f.config.Name,
+	}, nil
+}
+
+// FindActivation returns the activation of the domain, or nil if Fastly
+// does not serve a certificate for it
+func (f *FastlyAPI) FindActivation(ctx context.Context, domain string) (*FastlyActivation, error) {
+	resp, err := f.client.R().
+		SetContext(ctx).
+		SetQueryParam("filter[tls_domain.id]", domain).
+		SetResult(&fastlyActivationList{}).
+		Get(fmt.Sprintf("%s/tls/activations", fastlyApiUrl))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("Failed to list TLS activations for %s: %w", domain, &StatusError{resp.StatusCode(), string(resp.Body())})
+	}
+
+	list := resp.Result().(*fastlyActivationList)
+	if len(list.Data) == 0 {
+		return nil, nil
+	}
+
+	return &list.Data[0], nil
+}
+
+// AddActivation starts serving the certificate for the domain
+func (f *FastlyAPI) AddActivation(ctx context.Context, certId string, domain string) error {
+	resp, err := f.client.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"data": map[string]interface{}{
+				"type": "tls_activation",
+				"relationships": map[string]interface{}{
+					"tls_certificate": FastlyToOne{FastlyResourceIdentifier{certId, "tls_certificate"}},
+					"tls_domain":      FastlyToOne{FastlyResourceIdentifier{domain, "tls_domain"}},
+				},
+			},
+		}).
+		Post(fmt.Sprintf("%s/tls/activations", fastlyApiUrl))
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != 201 {
+		return &StatusError{resp.StatusCode(), string(resp.Body())}
+	}
+
+	return nil
+}
+
+// UpdateActivation switches the domain of the activation to the certificate
+func (f *FastlyAPI) UpdateActivation(ctx context.Context, activationId string, certId string) error {
+	resp, err := f.client.R().
+		SetContext(ctx).
+		SetBody(map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":   activationId,
+				"type": "tls_activation",
+				"relationships": map[string]interface{}{
+					"tls_certificate": FastlyToOne{FastlyResourceIdentifier{certId, "tls_certificate"}},
+				},
+			},
+		}).
+		Patch(fmt.Sprintf("%s/tls/activations/%s", fastlyApiUrl, activationId))
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != 200 {
+		return &StatusError{resp.StatusCode(), string(resp.Body())}
+	}
+
+	return nil
+}
+
+// publicKeySha1 returns the SHA-1 of the DER encoded public key, which
+// Fastly uses to identify private keys
+func publicKeySha1(cert *x509.Certificate) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha1.Sum(der)
+
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+)
+
+// loadHostnamePatterns reads the comma separated hostname patterns from
+// <PREFIX>_HOSTNAMES, the first prefix that has them set wins
+func loadHostnamePatterns(prefixes ...string) ([]string, error) {
+	for _, prefix := range prefixes {
+		value := env.GetOrDefaultString(prefix+"_HOSTNAMES", "")
+		if value == "" {
+			continue
+		}
+
+		var patterns []string
+
+		for _, pattern := range strings.Split(value, ",") {
+			pattern = strings.ToLower(strings.TrimSpace(pattern))
+
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Invalid hostname pattern %s in %s_HOSTNAMES: %v", pattern, prefix, err)
+			}
+
+			patterns = append(patterns, pattern)
+		}
+
+		return patterns, nil
+	}
+
+	return nil, nil
+}
+
+// FilterHostnames returns the hostnames matching any of the patterns, all
+// hostnames without patterns. A * in a pattern matches any part of a
+// hostname, so *.example.com matches a.example.com and a.b.example.com, and
+// a wildcard hostname *.example.com is matched by the same pattern.
+func FilterHostnames(hostnames []string, patterns []string) []string {
+	if patterns == nil {
+		return hostnames
+	}
+
+	var filtered []string
+
+	for _, hostname := range hostnames {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, strings.ToLower(hostname)); matched {
+				filtered = append(filtered, hostname)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
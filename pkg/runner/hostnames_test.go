@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterHostnames(t *testing.T) {
+	hostnames := []string{"example.com", "www.example.com", "img.cdn.example.com", "*.example.com", "example.org"}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"no patterns", nil, hostnames},
+		{"exact", []string{"example.org"}, []string{"example.org"}},
+		{"wildcard spans labels", []string{"*.example.com"}, []string{"www.example.com", "img.cdn.example.com", "*.example.com"}},
+		{"several patterns", []string{"example.com", "*.cdn.example.com"}, []string{"example.com", "img.cdn.example.com"}},
+		{"nothing matches", []string{"example.net"}, nil},
+		{"empty patterns match nothing", []string{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterHostnames(hostnames, tt.patterns); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FilterHostnames(%q) = %q, want %q", tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterHostnamesIgnoresCase(t *testing.T) {
+	got := FilterHostnames([]string{"WWW.Example.com"}, []string{"*.example.com"})
+
+	if !reflect.DeepEqual(got, []string{"WWW.Example.com"}) {
+		t.Errorf("FilterHostnames = %q, want the hostname unchanged", got)
+	}
+}
+
+func TestLoadHostnamePatterns(t *testing.T) {
+	t.Setenv("TESTCDN_IMAGES_HOSTNAMES", " IMG.example.com , *.cdn.example.com")
+	t.Setenv("TESTCDN_HOSTNAMES", "example.com")
+
+	patterns, err := loadHostnamePatterns("TESTCDN_IMAGES", "TESTCDN")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"img.example.com", "*.cdn.example.com"}; !reflect.DeepEqual(patterns, want) {
+		t.Errorf("loadHostnamePatterns = %q, want %q", patterns, want)
+	}
+
+	t.Setenv("TESTCDN_IMAGES_HOSTNAMES", "[example.com")
+
+	if _, err := loadHostnamePatterns("TESTCDN_IMAGES", "TESTCDN"); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
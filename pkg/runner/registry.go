@@ -0,0 +1,158 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v4/platform/config/env"
+)
+
+// Setting is an env var read by a runner
+type Setting struct {
+	Env         string
+	Description string
+	Required    bool
+	Default     string
+}
+
+// Settings holds the values of the settings of a runner keyed by env var
+type Settings map[string]string
+
+func (s Settings) String(key string) string {
+	return s[key]
+}
+
+func (s Settings) Int(key string) (int, error) {
+	if s[key] == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(s[key])
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number: %v", key, err)
+	}
+
+	return value, nil
+}
+
+// Factory creates runners of one type. Runners register their factory from
+// an init function, runners outside this module do so when they are imported
+type Factory struct {
+	Name        string
+	Description string
+	Settings    []Setting
+	New         func(settings Settings) (Runner, error)
+}
+
+// Load reads the settings from the environment, failing if a required one
+// is not set. For a named instance every setting is first read from the env
+// var with the instance name inserted after the runner prefix, for example
+// BUNNYCDN_IMAGES_PULL_ZONE_ID for instance images, falling back to the
+// shared one. Settings are keyed by their shared env var either way
+func (f Factory) Load(instance string) (Settings, error) {
+	settings := Settings{}
+	var missing []string
+
+	for _, setting := range f.Settings {
+		value := env.GetOrDefaultString(setting.Env, setting.Default)
+		name := setting.Env
+
+		if instance != "" {
+			name = f.InstanceEnv(instance, setting.Env)
+			value = env.GetOrDefaultString(name, value)
+		}
+
+		if setting.Required && value == "" {
+			missing = append(missing, name)
+		}
+
+		settings[setting.Env] = value
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s runner is missing %s", f.Name, strings.Join(missing, ", "))
+	}
+
+	return settings, nil
+}
+
+// Prefix is the env var prefix of the runner, or of one of its instances
+func (f Factory) Prefix(instance string) string {
+	prefix := strings.ToUpper(f.Name)
+
+	if instance != "" {
+		prefix += "_" + strings.ToUpper(strings.ReplaceAll(instance, "-", "_"))
+	}
+
+	return prefix
+}
+
+// InstanceEnv returns the env var of a setting for a named instance
+func (f Factory) InstanceEnv(instance string, key string) string {
+	return f.Prefix(instance) + strings.TrimPrefix(key, f.Prefix(""))
+}
+
+// ParseName splits a runner name like bunnycdn:images into its type and
+// instance name, the instance is empty for a plain runner type
+func ParseName(name string) (string, string, error) {
+	runnerType, instance, _ := strings.Cut(name, ":")
+
+	for _, c := range instance {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return "", "", fmt.Errorf("Invalid runner instance name %s, use lowercase letters, digits, - and _", instance)
+		}
+	}
+
+	if strings.Contains(name, ":") && instance == "" {
+		return "", "", fmt.Errorf("Missing instance name in runner %s", name)
+	}
+
+	return runnerType, instance, nil
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a runner available by name, registering a name twice panics
+func Register(factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[factory.Name]; ok {
+		panic(fmt.Sprintf("runner %s registered twice", factory.Name))
+	}
+
+	registry[factory.Name] = factory
+}
+
+// Lookup returns the factory registered for name
+func Lookup(name string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factory, ok := registry[name]
+
+	return factory, ok
+}
+
+// Factories returns all registered factories sorted by name
+func Factories() []Factory {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factories := make([]Factory, 0, len(registry))
+	for _, factory := range registry {
+		factories = append(factories, factory)
+	}
+
+	sort.Slice(factories, func(i, j int) bool {
+		return factories[i].Name < factories[j].Name
+	})
+
+	return factories
+}
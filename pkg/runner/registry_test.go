@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		name         string
+		wantType     string
+		wantInstance string
+		wantErr      bool
+	}{
+		{"bunnycdn", "bunnycdn", "", false},
+		{"bunnycdn:images", "bunnycdn", "images", false},
+		{"stackpath:eu-west_2", "stackpath", "eu-west_2", false},
+		{"bunnycdn:", "", "", true},
+		{"bunnycdn:Images", "", "", true},
+		{"bunnycdn:a.b", "", "", true},
+		{"bunnycdn:a:b", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runnerType, instance, err := ParseName(tt.name)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+
+			if runnerType != tt.wantType || instance != tt.wantInstance {
+				t.Errorf("ParseName(%q) = %q, %q, want %q, %q", tt.name, runnerType, instance, tt.wantType, tt.wantInstance)
+			}
+		})
+	}
+}
+
+func TestFactoryLoad(t *testing.T) {
+	factory := Factory{
+		Name: "testcdn",
+		Settings: []Setting{
+			{Env: "TESTCDN_API_KEY", Required: true},
+			{Env: "TESTCDN_ZONE_ID", Required: true},
+			{Env: "TESTCDN_MODE", Default: "full"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		instance string
+		env      map[string]string
+		want     Settings
+		wantErr  string
+	}{
+		{
+			name: "shared settings",
+			env:  map[string]string{"TESTCDN_API_KEY": "key", "TESTCDN_ZONE_ID": "1"},
+			want: Settings{"TESTCDN_API_KEY": "key", "TESTCDN_ZONE_ID": "1", "TESTCDN_MODE": "full"},
+		},
+		{
+			name:     "instance falls back to shared settings",
+			instance: "images",
+			env:      map[string]string{"TESTCDN_API_KEY": "key", "TESTCDN_ZONE_ID": "1", "TESTCDN_IMAGES_ZONE_ID": "2"},
+			want:     Settings{"TESTCDN_API_KEY": "key", "TESTCDN_ZONE_ID": "2", "TESTCDN_MODE": "full"},
+		},
+		{
+			name:     "instance overrides default",
+			instance: "eu-west",
+			env:      map[string]string{"TESTCDN_API_KEY": "key", "TESTCDN_EU_WEST_ZONE_ID": "3", "TESTCDN_EU_WEST_MODE": "flexible"},
+			want:     Settings{"TESTCDN_API_KEY": "key", "TESTCDN_ZONE_ID": "3", "TESTCDN_MODE": "flexible"},
+		},
+		{
+			name:    "missing required settings",
+			env:     map[string]string{},
+			wantErr: "testcdn runner is missing TESTCDN_API_KEY, TESTCDN_ZONE_ID",
+		},
+		{
+			name:     "missing instance setting names the instance env var",
+			instance: "images",
+			env:      map[string]string{"TESTCDN_API_KEY": "key"},
+			wantErr:  "testcdn runner is missing TESTCDN_IMAGES_ZONE_ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"TESTCDN_API_KEY", "TESTCDN_ZONE_ID", "TESTCDN_MODE", "TESTCDN_IMAGES_ZONE_ID", "TESTCDN_EU_WEST_ZONE_ID", "TESTCDN_EU_WEST_MODE"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			settings, err := factory.Load(tt.instance)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load(%q) error = %v, want %q", tt.instance, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Load(%q) error = %v", tt.instance, err)
+			}
+
+			if !reflect.DeepEqual(settings, tt.want) {
+				t.Errorf("Load(%q) = %v, want %v", tt.instance, settings, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+// StatusError is returned by the API clients when a provider responds with
+// an unsuccessful HTTP status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", http.StatusText(e.StatusCode), e.StatusCode, e.Body)
+}
+
+// RetryPolicy controls how often a failing runner is retried and how long a
+// single attempt may take
+type RetryPolicy struct {
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+}
+
+// NewRetryPolicy reads the policy of a runner from <PREFIX>_RETRY_ATTEMPTS,
+// <PREFIX>_RETRY_BACKOFF, <PREFIX>_RETRY_MAX_BACKOFF and <PREFIX>_TIMEOUT.
+// Prefixes are tried from the last to the first, so the first, most specific
+// one wins, falling back to the AUTOCERT_RUNNER_* defaults shared by all
+// runners
+func NewRetryPolicy(prefixes ...string) RetryPolicy {
+	policy := RetryPolicy{
+		Attempts:       env.GetOrDefaultInt("AUTOCERT_RUNNER_RETRY_ATTEMPTS", 3),
+		InitialBackoff: env.GetOrDefaultSecond("AUTOCERT_RUNNER_RETRY_BACKOFF", 2*time.Second),
+		MaxBackoff:     env.GetOrDefaultSecond("AUTOCERT_RUNNER_RETRY_MAX_BACKOFF", time.Minute),
+		Timeout:        env.GetOrDefaultSecond("AUTOCERT_RUNNER_TIMEOUT", 2*time.Minute),
+	}
+
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		prefix := prefixes[i]
+
+		policy.Attempts = env.GetOrDefaultInt(prefix+"_RETRY_ATTEMPTS", policy.Attempts)
+		policy.InitialBackoff = env.GetOrDefaultSecond(prefix+"_RETRY_BACKOFF", policy.InitialBackoff)
+		policy.MaxBackoff = env.GetOrDefaultSecond(prefix+"_RETRY_MAX_BACKOFF", policy.MaxBackoff)
+		policy.Timeout = env.GetOrDefaultSecond(prefix+"_TIMEOUT", policy.Timeout)
+	}
+
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+
+	return policy
+}
+
+// backoff returns the delay before the given retry, doubling with every
+// attempt and adding up to 20% jitter so runners don't retry in lockstep
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+
+	// double step by step, shifting by retry could overflow into a short delay
+	for i := 0; i < retry && delay > 0 && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay <= 0 || delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	}
+
+	return delay
+}
+
+// Do runs fn until it succeeds, fails with a permanent error, all attempts
+// are used up or ctx is done. Every attempt gets its own timeout. It returns
+// the number of attempts made
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) (int, error) {
+	log := logging.FromContext(ctx)
+
+	for attempt := 1; ; attempt++ {
+		err := p.attempt(ctx, fn)
+
+		if err == nil || attempt >= p.Attempts || ctx.Err() != nil || !IsRetryable(err) {
+			return attempt, err
+		}
+
+		delay := p.backoff(attempt - 1)
+		log.Warnf("Attempt %d of %d failed: %s, retrying in %s", attempt, p.Attempts, err.Error(), delay)
+
+		select {
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%v, giving up: %w", err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (p RetryPolicy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	err := fn(attemptCtx)
+
+	if err != nil && attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("timed out after %s: %w", p.Timeout, err)
+	}
+
+	return err
+}
+
+// IsRetryable reports whether err is transient: network errors, timeouts,
+// rate limits and server errors. Other errors, like rejected certificates or missing
+// hostnames, are permanent and would fail again
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode)
+	}
+
+	var cloudflareErr *cloudflare.APIRequestError
+	if errors.As(err, &cloudflareErr) {
+		return retryableStatus(cloudflareErr.StatusCode)
+	}
+
+	var bunnyAPIErr *bunny.APIError
+	if errors.As(err, &bunnyAPIErr) {
+		return retryableStatus(bunnyAPIErr.StatusCode)
+	}
+
+	var bunnyErr *bunny.HTTPError
+	if errors.As(err, &bunnyErr) {
+		return retryableStatus(bunnyErr.StatusCode)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// the connection was closed before a response was received
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	bunny "github.com/simplesurance/bunny-go"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"wrapped deadline exceeded", fmt.Errorf("timed out after 2m: %w", context.DeadlineExceeded), true},
+		{"canceled", context.Canceled, false},
+		{"rate limited", &StatusError{StatusCode: 429}, true},
+		{"request timeout", &StatusError{StatusCode: 408}, true},
+		{"server error", &StatusError{StatusCode: 503}, true},
+		{"bad request", &StatusError{StatusCode: 400}, false},
+		{"unauthorized", &StatusError{StatusCode: 401}, false},
+		{"wrapped status", fmt.Errorf("Failed to list certificates: %w", &StatusError{StatusCode: 502}), true},
+		{"cloudflare server error", &cloudflare.APIRequestError{StatusCode: 500}, true},
+		{"cloudflare rejected", &cloudflare.APIRequestError{StatusCode: 400}, false},
+		{"bunny api error", &bunny.APIError{HTTPError: bunny.HTTPError{StatusCode: 429}}, true},
+		{"bunny rejected", &bunny.APIError{HTTPError: bunny.HTTPError{StatusCode: 400}}, false},
+		{"bunny http error", &bunny.HTTPError{StatusCode: 504}, true},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"connection closed", fmt.Errorf("Post: %w", io.ErrUnexpectedEOF), true},
+		{"eof", io.EOF, true},
+		{"permanent", errors.New("Hostname example.com missing, add hostname first"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 2 * time.Second, MaxBackoff: time.Minute}
+
+	tests := []struct {
+		retry int
+		base  time.Duration
+	}{
+		{0, 2 * time.Second},
+		{1, 4 * time.Second},
+		{2, 8 * time.Second},
+		{4, 32 * time.Second},
+		{5, time.Minute},
+		{70, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("retry %d", tt.retry), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				delay := policy.backoff(tt.retry)
+
+				if delay < tt.base || delay > tt.base+tt.base/5 {
+					t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.retry, delay, tt.base, tt.base+tt.base/5)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffDoesNotOverflow(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 3 * time.Second, MaxBackoff: time.Minute}
+
+	for retry := 5; retry < 100; retry++ {
+		if delay := policy.backoff(retry); delay < time.Minute {
+			t.Fatalf("backoff(%d) = %s, want at least %s", retry, delay, time.Minute)
+		}
+	}
+}
+
+func TestBackoffWithoutDelay(t *testing.T) {
+	if delay := (RetryPolicy{}).backoff(3); delay != 0 {
+		t.Errorf("backoff without delays = %s, want 0", delay)
+	}
+}
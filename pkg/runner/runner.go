@@ -3,17 +3,28 @@ package runner
 import (
 	"context"
 	"fmt"
-	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-acme/lego/v4/challenge"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/metrics"
 	"github.com/maxroll/auto-cert/pkg/requestor"
 	"github.com/maxroll/auto-cert/pkg/secrets"
-	"golang.org/x/sync/errgroup"
 )
 
 type Runner interface {
-	Exec(hostnames []string, certificate *requestor.Certificate) error
+	Name() string
+	// Target identifies the provider resource the runner deploys to
+	Target() string
+	// Exec deploys the certificate and returns a description of every
+	// change made at the provider. The runner logs through the logger of ctx
+	// and must give up once ctx is done
+	Exec(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error)
+	// Plan describes what Exec would change without changing anything,
+	// certificate is nil when a new certificate would be requested
+	Plan(ctx context.Context, hostnames []string, certificate *requestor.Certificate) (string, error)
 }
 
 type Bootstrap struct {
@@ -24,50 +35,185 @@ type Bootstrap struct {
 
 type RunnerManager struct {
 	Runners []Runner
-	*sync.WaitGroup
+	// Retries holds the retry policy of the runner with the same index
+	Retries []RetryPolicy
+	// Hostnames holds the hostname patterns served by the runner with the
+	// same index, nil serves all hostnames
+	Hostnames [][]string
 }
 
+// NewRunnerManager creates the runners by name. A name is a registered
+// runner type, optionally followed by an instance name, e.g. bunnycdn:images,
+// so one runner type can deploy to several targets with their own settings
 func NewRunnerManager(runners []string) (*RunnerManager, error) {
-	waitGroup := &sync.WaitGroup{}
-
 	var runnerInstances []Runner
+	var retries []RetryPolicy
+	var patterns [][]string
+
+	seen := map[string]bool{}
 
 	for _, runnerName := range runners {
-		if runnerName == "bunnycdn" {
-			runnerInstances = append(runnerInstances, NewBunnyCDNRunner())
-		} else if runnerName == "stackpath" {
-			runner, err := NewStackPathRunner()
+		runnerName = strings.TrimSpace(runnerName)
 
-			if err != nil {
-				return nil, err
-			}
+		if seen[runnerName] {
+			return nil, fmt.Errorf("Runner %s configured twice", runnerName)
+		}
+		seen[runnerName] = true
+
+		runnerType, instance, err := ParseName(runnerName)
+		if err != nil {
+			return nil, err
+		}
+
+		factory, ok := Lookup(runnerType)
+		if !ok {
+			return nil, fmt.Errorf("Unknown runner: %s", runnerType)
+		}
+
+		settings, err := factory.Load(instance)
+		if err != nil {
+			return nil, err
+		}
+
+		runner, err := factory.New(settings)
+		if err != nil {
+			return nil, err
+		}
+
+		if instance != "" {
+			runner = &namedRunner{runner, runnerName}
+		}
 
-			runnerInstances = append(runnerInstances, runner)
-		} else {
-			return nil, fmt.Errorf("Unknown runner: %s", runnerName)
+		runnerInstances = append(runnerInstances, runner)
+		retries = append(retries, NewRetryPolicy(factory.Prefix(instance), factory.Prefix("")))
+
+		hostnamePatterns, err := loadHostnamePatterns(factory.Prefix(instance), factory.Prefix(""))
+		if err != nil {
+			return nil, err
 		}
+		patterns = append(patterns, hostnamePatterns)
 	}
 
-	waitGroup.Add(len(runners))
+	return &RunnerManager{runnerInstances, retries, patterns}, nil
+}
+
+// namedRunner reports the instance name instead of the runner type, so
+// results, logs and metrics tell instances apart
+type namedRunner struct {
+	Runner
+	name string
+}
+
+func (r *namedRunner) Name() string {
+	return r.name
+}
+
+// Result is the outcome of a single runner
+type Result struct {
+	Runner  string `json:"runner"`
+	Target  string `json:"target"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// Skipped is set when none of the hostnames is served by the runner
+	Skipped bool `json:"skipped,omitempty"`
+	// Attempts is the number of times Exec was called
+	Attempts int `json:"attempts"`
+	// Duration includes all attempts and the backoff between them
+	Duration float64  `json:"duration_seconds"`
+	Changes  []string `json:"changes,omitempty"`
+}
+
+// Failed returns the results of the runners that failed
+func Failed(results []Result) []Result {
+	var failed []Result
 
-	return &RunnerManager{runnerInstances, waitGroup}, nil
+	for _, result := range results {
+		if !result.Success {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
 }
 
-func (r *RunnerManager) Run(hostnames []string, certificate *requestor.Certificate) {
-	ctx := context.Background()
+// Run executes all runners concurrently and waits for every one of them, a
+// failing runner does not stop the others. Every attempt is bounded by the
+// timeout of the runner's retry policy
+func (r *RunnerManager) Run(ctx context.Context, hostnames []string, certificate *requestor.Certificate) []Result {
+	var wg sync.WaitGroup
+
+	results := make([]Result, len(r.Runners))
+
+	for i, runner := range r.Runners {
+		wg.Add(1)
+
+		go func(i int, execRunner Runner) {
+			defer wg.Done()
+
+			runnerLog := logging.FromContext(ctx).With("runner", execRunner.Name())
+			runnerCtx := logging.NewContext(ctx, runnerLog)
+			start := time.Now()
+
+			hostnames := FilterHostnames(hostnames, r.Hostnames[i])
+			if len(hostnames) == 0 {
+				runnerLog.Infof("None of the hostnames is served by runner %s, skipping", execRunner.Name())
+				results[i] = Result{Runner: execRunner.Name(), Target: execRunner.Target(), Success: true, Skipped: true}
+				return
+			}
+
+			var changes []string
+
+			attempts, err := r.Retries[i].Do(runnerCtx, func(ctx context.Context) error {
+				attemptStart := time.Now()
+				attemptChanges, err := execRunner.Exec(ctx, hostnames, certificate)
+				metrics.RunnerExecuted(execRunner.Name(), time.Since(attemptStart), err)
+
+				// keep what partially failed attempts changed as well
+				changes = append(changes, attemptChanges...)
 
-	errs, ctx := errgroup.WithContext(ctx)
+				return err
+			})
 
-	for _, runner := range r.Runners {
-		execRunner := runner
-		errs.Go(func() error {
-			return execRunner.Exec(hostnames, certificate)
-		})
+			results[i] = Result{
+				Runner:   execRunner.Name(),
+				Target:   execRunner.Target(),
+				Success:  err == nil,
+				Attempts: attempts,
+				Duration: time.Since(start).Seconds(),
+				Changes:  changes,
+			}
+
+			if err != nil {
+				results[i].Error = err.Error()
+				runnerLog.Errorf("Runner %s failed: %s", execRunner.Name(), err.Error())
+			}
+		}(i, runner)
 	}
 
-	err := errs.Wait()
+	wg.Wait()
+
+	return results
+}
+
+func (r *RunnerManager) Plan(ctx context.Context, hostnames []string, certificate *requestor.Certificate) {
+	log := logging.FromContext(ctx)
+
+	for i, runner := range r.Runners {
+		hostnames := FilterHostnames(hostnames, r.Hostnames[i])
+		if len(hostnames) == 0 {
+			log.With("runner", runner.Name()).Infof("[dry-run] %s runner would be skipped, it serves none of the hostnames", runner.Name())
+			continue
+		}
+
+		planCtx, cancel := context.WithTimeout(logging.NewContext(ctx, log.With("runner", runner.Name())), r.Retries[i].Timeout)
+		plan, err := runner.Plan(planCtx, hostnames, certificate)
+		cancel()
+
+		if err != nil {
+			log.With("runner", runner.Name()).Warnf("[dry-run] %s runner would fail: %s", runner.Name(), err.Error())
+			continue
+		}
 
-	if err != nil {
-		log.Printf("Runner failed: %s", err.Error())
+		log.With("runner", runner.Name()).Infof("[dry-run] %s runner would %s", runner.Name(), plan)
 	}
 }
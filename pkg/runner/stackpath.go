@@ -3,10 +3,9 @@ package runner
 import (
 	"context"
 	"fmt"
-	"log"
-	"sync"
 
-	"github.com/go-acme/lego/v4/platform/config/env"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/maxroll/auto-cert/pkg/logging"
 	"github.com/maxroll/auto-cert/pkg/requestor"
 	"github.com/maxroll/auto-cert/pkg/util"
 )
@@ -20,74 +19,178 @@ type StackPathConfig struct {
 
 type StackPathRunner struct {
 	config *StackPathConfig
-	context.Context
 	*StackPathAPI
-	*sync.WaitGroup
 }
 
-func NewStackPathRunner() (*StackPathRunner, error) {
+func init() {
+	Register(Factory{
+		Name:        "stackpath",
+		Description: "Creates or updates the certificate in a StackPath stack",
+		Settings: []Setting{
+			{Env: "STACKPATH_API_CLIENT_ID", Description: "API client ID", Required: true},
+			{Env: "STACKPATH_API_CLIENT_SECRET", Description: "API client secret", Required: true},
+			{Env: "STACKPATH_STACK_ID", Description: "ID of the stack", Required: true},
+			{Env: "STACKPATH_SITE_ID", Description: "ID of the site"},
+		},
+		New: func(settings Settings) (Runner, error) {
+			return NewStackPathRunner(settings)
+		},
+	})
+}
+
+func NewStackPathRunner(settings Settings) (*StackPathRunner, error) {
 	config := &StackPathConfig{
-		ClientId:     env.GetOrDefaultString("STACKPATH_API_CLIENT_ID", ""),
-		ClientSecret: env.GetOrDefaultString("STACKPATH_API_CLIENT_SECRET", ""),
-		StackId:      env.GetOrDefaultString("STACKPATH_STACK_ID", ""),
-		SiteId:       env.GetOrDefaultString("STACKPATH_SITE_ID", ""),
+		ClientId:     settings.String("STACKPATH_API_CLIENT_ID"),
+		ClientSecret: settings.String("STACKPATH_API_CLIENT_SECRET"),
+		StackId:      settings.String("STACKPATH_STACK_ID"),
+		SiteId:       settings.String("STACKPATH_SITE_ID"),
 	}
 
-	client, err := newStackPathAPI(config)
+	ctx, cancel := context.WithTimeout(context.Background(), stackPathRequestTimeout)
+	defer cancel()
+
+	client, err := newStackPathAPI(ctx, config)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &StackPathRunner{config, context.Background(), client, nil}, nil
+	return &StackPathRunner{config, client}, nil
+}
+
+func (r *StackPathRunner) Name() string {
+	return "stackpath"
+}
+
+func (r *StackPathRunner) Target() string {
+	return fmt.Sprintf("stack/%s", r.config.StackId)
 }
 
-func (r *StackPathRunner) Exec(hostnames []string, certificate *requestor.Certificate) error {
-	log.Printf("[StackPath Runner] Updating certificate in StackPath")
+func (r *StackPathRunner) Exec(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error) {
+	log := logging.FromContext(ctx)
+
+	log.Infof("Updating certificate in StackPath")
 
 	if certificate == nil {
-		return fmt.Errorf("No certificate available")
+		return nil, fmt.Errorf("No certificate available")
 	}
 
-	certs, err := r.StackPathAPI.ListCertificates()
+	certId, err := r.findCertificate(ctx, certificateSANs(hostnames, certificate))
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	certId := ""
-
-	for _, cert := range certs.Certificates {
-		if util.StringSlicesEqual(cert.SubjectAlternativeNames, hostnames) {
-			certId = cert.ID
-			break
-		}
-	}
+	var change string
 
 	if certId != "" {
-		log.Println("[StackPath Runner] Cert for these hostnames already exists, updating...")
+		log.Infof("Cert for these hostnames already exists, updating...")
 
-		err = r.StackPathAPI.UpdateCertificates(certId, certificate)
+		err = r.StackPathAPI.UpdateCertificates(ctx, certId, certificate)
 
 		if err != nil {
-			return fmt.Errorf("[StackPath Runner] Failed to update certificate %s: %s", certId, err.Error())
+			return nil, fmt.Errorf("[StackPath Runner] Failed to update certificate %s: %w", certId, err)
 		}
 
-		log.Println("[StackPath Runner] Certificate updated")
+		change = fmt.Sprintf("updated certificate %s in stack %s", certId, r.config.StackId)
+
+		log.Infof("Certificate updated")
 
 	} else {
 
-		err := r.StackPathAPI.AddCertificates(certificate)
+		err := r.StackPathAPI.AddCertificates(ctx, certificate)
 
 		if err != nil {
-			return fmt.Errorf("[StackPath Runner] Failed to add certificate to stack: %s", err.Error())
+			return nil, fmt.Errorf("[StackPath Runner] Failed to add certificate to stack: %w", err)
 		}
 
-		log.Println("[StackPath Runner] Certificate created")
+		change = fmt.Sprintf("created certificate for %s in stack %s", hostnames, r.config.StackId)
+
+		log.Infof("Certificate created")
 	}
 
-	log.Println("[StackPath Runner] StackPath runner finished!")
+	log.Infof("StackPath runner finished!")
+
+	return []string{change}, nil
+
+}
+
+func (r *StackPathRunner) Plan(ctx context.Context, hostnames []string, certificate *requestor.Certificate) (string, error) {
+	certId, err := r.findCertificate(ctx, certificateSANs(hostnames, certificate))
+
+	if err != nil {
+		return "", err
+	}
+
+	if certId != "" {
+		return fmt.Sprintf("update certificate %s in stack %s", certId, r.config.StackId), nil
+	}
+
+	return fmt.Sprintf("create certificate for %s in stack %s", hostnames, r.config.StackId), nil
+}
+
+// Verify compares the active certificate in the stack with the stored one by
+// its fingerprint and expiration date
+func (r *StackPathRunner) Verify(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error) {
+	leaf, err := certcrypto.ParsePEMCertificate(certificate.Certificate)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	certs, err := r.StackPathAPI.ListCertificates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cert := range certs.Certificates {
+		if !util.StringSlicesEqual(cert.SubjectAlternativeNames, leaf.DNSNames) {
+			continue
+		}
+
+		if cert.Fingerprint != "" && !util.FingerprintMatches(leaf, cert.Fingerprint) {
+			return []string{fmt.Sprintf("stack %s serves certificate %s with fingerprint %s", r.config.StackId, cert.ID, cert.Fingerprint)}, nil
+		}
+
+		if !cert.ExpirationDate.Equal(leaf.NotAfter) {
+			return []string{fmt.Sprintf("stack %s serves certificate %s expiring %s, stored certificate expires %s", r.config.StackId, cert.ID, cert.ExpirationDate, leaf.NotAfter)}, nil
+		}
+
+		return nil, nil
+	}
+
+	return []string{fmt.Sprintf("no active certificate for %s in stack %s", leaf.DNSNames, r.config.StackId)}, nil
+}
+
+// certificateSANs returns the SANs of the certificate, the runner may only be
+// given some of them when it serves a subset of the hostnames. The hostnames
+// are used when there is no certificate yet
+func certificateSANs(hostnames []string, certificate *requestor.Certificate) []string {
+	if certificate == nil {
+		return hostnames
+	}
+
+	cert, err := certcrypto.ParsePEMCertificate(certificate.Certificate)
+	if err != nil {
+		return hostnames
+	}
+
+	return cert.DNSNames
+}
+
+// findCertificate returns the ID of the active certificate matching the
+// hostnames, or an empty string if there is none
+func (r *StackPathRunner) findCertificate(ctx context.Context, hostnames []string) (string, error) {
+	certs, err := r.StackPathAPI.ListCertificates(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, cert := range certs.Certificates {
+		if util.StringSlicesEqual(cert.SubjectAlternativeNames, hostnames) {
+			return cert.ID, nil
+		}
+	}
 
+	return "", nil
 }
@@ -1,7 +1,10 @@
 package runner
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
@@ -46,8 +49,11 @@ type Certificate struct {
 
 type StackPathAPI struct {
 	config *StackPathConfig
-	token  *Token
 	client *resty.Client
+
+	mu        sync.Mutex
+	token     *Token
+	expiresAt time.Time
 }
 
 type Token struct {
@@ -62,44 +68,114 @@ type TokenInput struct {
 	GrantType    string `json:"grant_type"`
 }
 
-func newStackPathAPI(config *StackPathConfig) (*StackPathAPI, error) {
+// tokenExpiryMargin renews the bearer token before it actually expires, so
+// it does not expire during a request
+const tokenExpiryMargin = time.Minute
+
+// stackPathRequestTimeout bounds every request, including the token fetch
+// made while the runner is created
+const stackPathRequestTimeout = 30 * time.Second
+
+func newStackPathAPI(ctx context.Context, config *StackPathConfig) (*StackPathAPI, error) {
 	client := resty.New()
+	client.SetTimeout(stackPathRequestTimeout)
 
 	client.SetHeader("Accept", "application/json")
 	client.SetHeader("Content-Type", "application/json")
 
-	resp, err := client.R().
-		SetBody(TokenInput{config.ClientId, config.ClientSecret, "client_credentials"}).
+	api := &StackPathAPI{config: config, client: client}
+
+	// fetch the first token right away so invalid credentials fail early
+	if _, err := api.accessToken(ctx); err != nil {
+		return nil, err
+	}
+
+	return api, nil
+}
+
+// accessToken returns the bearer token, fetching a new one when it expired.
+// The runners live as long as the daemon, much longer than a token
+func (s *StackPathAPI) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && time.Now().Before(s.expiresAt) {
+		return s.token.AccessToken, nil
+	}
+
+	resp, err := s.client.R().
+		SetContext(ctx).
+		SetBody(TokenInput{s.config.ClientId, s.config.ClientSecret, "client_credentials"}).
 		SetResult(&Token{}).
 		Post(fmt.Sprintf("%s/identity/v1/oauth2/token", stackPathApiUrl))
 
-	if err != nil || resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("[StackPath] Could not fetch bearer token (%s)", resp.Request.URL)
+	if err != nil {
+		return "", fmt.Errorf("[StackPath] Could not fetch bearer token: %w", err)
 	}
 
-	token := resp.Result().(*Token)
-	client.SetAuthToken(token.AccessToken)
+	if resp.StatusCode() != 200 {
+		return "", fmt.Errorf("[StackPath] Could not fetch bearer token: %w", &StatusError{resp.StatusCode(), string(resp.Body())})
+	}
+
+	s.token = resp.Result().(*Token)
+	s.expiresAt = time.Now().Add(time.Duration(s.token.ExpiresIn)*time.Second - tokenExpiryMargin)
 
-	return &StackPathAPI{config, token, client}, nil
+	return s.token.AccessToken, nil
 }
 
-func (s *StackPathAPI) ListCertificates() (*CertificateResult, error) {
-	resp, err := s.client.R().
-		SetResult(&CertificateResult{}).
-		Get(fmt.Sprintf("%s/cdn/v1/stacks/%s/certificates?page_request.filter=%s", stackPathApiUrl, s.config.StackId, "status%3D%22ACTIVE%22"))
+// expireToken makes the next request fetch a new token, unless the rejected
+// token was already replaced
+func (s *StackPathAPI) expireToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.token.AccessToken == token {
+		s.token = nil
+	}
+}
+
+// request sends the request built by send with the current bearer token. A
+// rejected token is replaced and the request sent once more
+func (s *StackPathAPI) request(ctx context.Context, send func(*resty.Request) (*resty.Response, error)) (*resty.Response, error) {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := send(s.client.R().SetContext(ctx).SetAuthToken(token))
+	if err != nil || resp.StatusCode() != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	s.expireToken(token)
+
+	token, err = s.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return send(s.client.R().SetContext(ctx).SetAuthToken(token))
+}
+
+func (s *StackPathAPI) ListCertificates(ctx context.Context) (*CertificateResult, error) {
+	resp, err := s.request(ctx, func(req *resty.Request) (*resty.Response, error) {
+		return req.
+			SetResult(&CertificateResult{}).
+			Get(fmt.Sprintf("%s/cdn/v1/stacks/%s/certificates?page_request.filter=%s", stackPathApiUrl, s.config.StackId, "status%3D%22ACTIVE%22"))
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("Failed to list certificates for Stack %s (%s)", s.config.StackId, string(resp.Body()))
+		return nil, fmt.Errorf("Failed to list certificates for Stack %s: %w", s.config.StackId, &StatusError{resp.StatusCode(), string(resp.Body())})
 	}
 
 	return resp.Result().(*CertificateResult), nil
 }
 
-func (s *StackPathAPI) AddCertificates(certificate *requestor.Certificate) error {
+func (s *StackPathAPI) AddCertificates(ctx context.Context, certificate *requestor.Certificate) error {
 
 	bundle, err := util.SplitCerts(certificate)
 
@@ -107,26 +183,28 @@ func (s *StackPathAPI) AddCertificates(certificate *requestor.Certificate) error
 		return err
 	}
 
-	resp, err := s.client.R().
-		SetBody(CertificateInput{
-			Certificate: string(bundle.Certificate),
-			Key:         string(certificate.PrivateKey),
-			CaBundle:    string(bundle.CaBundle),
-		}).
-		Post(fmt.Sprintf("%s/cdn/v1/stacks/%s/certificates", stackPathApiUrl, s.config.StackId))
+	resp, err := s.request(ctx, func(req *resty.Request) (*resty.Response, error) {
+		return req.
+			SetBody(CertificateInput{
+				Certificate: string(bundle.Certificate),
+				Key:         string(certificate.PrivateKey),
+				CaBundle:    string(bundle.CaBundle),
+			}).
+			Post(fmt.Sprintf("%s/cdn/v1/stacks/%s/certificates", stackPathApiUrl, s.config.StackId))
+	})
 
 	if err != nil {
 		return err
 	}
 
 	if resp.StatusCode() != 200 {
-		return fmt.Errorf(string(resp.Body()))
+		return &StatusError{resp.StatusCode(), string(resp.Body())}
 	}
 
 	return nil
 }
 
-func (s *StackPathAPI) UpdateCertificates(certId string, certificate *requestor.Certificate) error {
+func (s *StackPathAPI) UpdateCertificates(ctx context.Context, certId string, certificate *requestor.Certificate) error {
 
 	bundle, err := util.SplitCerts(certificate)
 
@@ -134,20 +212,22 @@ func (s *StackPathAPI) UpdateCertificates(certId string, certificate *requestor.
 		return err
 	}
 
-	resp, err := s.client.R().
-		SetBody(CertificateInput{
-			Certificate: string(bundle.Certificate),
-			Key:         string(certificate.PrivateKey),
-			CaBundle:    string(bundle.CaBundle),
-		}).
-		Put(fmt.Sprintf("%s/cdn/v1/stacks/%s/certificates/%s", stackPathApiUrl, s.config.StackId, certId))
+	resp, err := s.request(ctx, func(req *resty.Request) (*resty.Response, error) {
+		return req.
+			SetBody(CertificateInput{
+				Certificate: string(bundle.Certificate),
+				Key:         string(certificate.PrivateKey),
+				CaBundle:    string(bundle.CaBundle),
+			}).
+			Put(fmt.Sprintf("%s/cdn/v1/stacks/%s/certificates/%s", stackPathApiUrl, s.config.StackId, certId))
+	})
 
 	if err != nil {
 		return err
 	}
 
 	if resp.StatusCode() != 200 {
-		return fmt.Errorf(string(resp.Body()))
+		return &StatusError{resp.StatusCode(), string(resp.Body())}
 	}
 
 	return nil
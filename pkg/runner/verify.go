@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"github.com/maxroll/auto-cert/pkg/requestor"
+)
+
+// Verifier is implemented by runners able to report which certificate is
+// deployed at the provider
+type Verifier interface {
+	// Verify compares the deployed certificate with the given one and
+	// describes every difference found, none means the deployment is in sync
+	Verify(ctx context.Context, hostnames []string, certificate *requestor.Certificate) ([]string, error)
+}
+
+// Verification is the outcome of verifying a single runner
+type Verification struct {
+	Runner string   `json:"runner"`
+	Target string   `json:"target"`
+	Drift  []string `json:"drift,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	// Unsupported is set for runners that can not report their deployment
+	Unsupported bool `json:"unsupported,omitempty"`
+}
+
+// Drifted reports whether the runner needs to be deployed again. A failed
+// verification counts as drift, the deployment can not be trusted
+func (v Verification) Drifted() bool {
+	return len(v.Drift) > 0 || v.Error != ""
+}
+
+// Verify asks every runner which certificate it has deployed for the
+// hostnames it serves
+func (r *RunnerManager) Verify(ctx context.Context, hostnames []string, certificate *requestor.Certificate) []Verification {
+	verifications := make([]Verification, 0, len(r.Runners))
+
+	for i, runner := range r.Runners {
+		verification := Verification{Runner: runner.Name(), Target: runner.Target()}
+
+		runnerHostnames := FilterHostnames(hostnames, r.Hostnames[i])
+		verifier, ok := unwrap(runner).(Verifier)
+
+		if !ok {
+			verification.Unsupported = true
+		} else if len(runnerHostnames) > 0 {
+			runnerLog := logging.FromContext(ctx).With("runner", runner.Name())
+			verifyCtx, cancel := context.WithTimeout(logging.NewContext(ctx, runnerLog), r.Retries[i].Timeout)
+
+			drift, err := verifier.Verify(verifyCtx, runnerHostnames, certificate)
+			cancel()
+
+			verification.Drift = drift
+			if err != nil {
+				verification.Error = err.Error()
+			}
+		}
+
+		verifications = append(verifications, verification)
+	}
+
+	return verifications
+}
+
+// Serving returns the names of the runners deploying any of the hostnames
+func (r *RunnerManager) Serving(hostnames []string) []string {
+	var names []string
+
+	for i, runner := range r.Runners {
+		if len(FilterHostnames(hostnames, r.Hostnames[i])) > 0 {
+			names = append(names, runner.Name())
+		}
+	}
+
+	return names
+}
+
+// Subset returns a manager with only the named runners
+func (r *RunnerManager) Subset(names []string) *RunnerManager {
+	subset := &RunnerManager{}
+
+	for i, runner := range r.Runners {
+		for _, name := range names {
+			if runner.Name() == name {
+				subset.Runners = append(subset.Runners, runner)
+				subset.Retries = append(subset.Retries, r.Retries[i])
+				subset.Hostnames = append(subset.Hostnames, r.Hostnames[i])
+				break
+			}
+		}
+	}
+
+	return subset
+}
+
+// unwrap returns the runner behind an instance name
+func unwrap(runner Runner) Runner {
+	if named, ok := runner.(*namedRunner); ok {
+		return named.Runner
+	}
+
+	return runner
+}
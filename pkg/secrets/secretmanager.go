@@ -4,10 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/maxroll/auto-cert/pkg/logging"
+	"google.golang.org/api/iterator"
 	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type SecretManagerConfig struct {
@@ -17,10 +23,25 @@ type SecretManagerConfig struct {
 	Version   string
 }
 
+// secretManagerClient is the part of the Secret Manager client used by the
+// backend, so tests can replace it
+type secretManagerClient interface {
+	CreateSecret(ctx context.Context, req *secretmanagerpb.CreateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error)
+	GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error)
+	UpdateSecret(ctx context.Context, req *secretmanagerpb.UpdateSecretRequest, opts ...gax.CallOption) (*secretmanagerpb.Secret, error)
+	AddSecretVersion(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	GetSecretVersion(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error)
+	ListSecretVersions(ctx context.Context, req *secretmanagerpb.ListSecretVersionsRequest, opts ...gax.CallOption) *secretmanager.SecretVersionIterator
+	EnableSecretVersion(ctx context.Context, req *secretmanagerpb.EnableSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	DisableSecretVersion(ctx context.Context, req *secretmanagerpb.DisableSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	DestroySecretVersion(ctx context.Context, req *secretmanagerpb.DestroySecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error)
+	Close() error
+}
+
 type SecretManagerBackend struct {
 	config *SecretManagerConfig
-	ctx    context.Context
-	client *secretmanager.Client
+	client secretManagerClient
 }
 
 func (s *SecretManagerBackend) GetName(includeVersion bool) string {
@@ -39,16 +60,51 @@ func (s *SecretManagerBackend) GetName(includeVersion bool) string {
 	return secretName
 }
 
-func (s *SecretManagerBackend) GetSecret() *Secret {
+// currentVersion returns the version holding the current secret. With
+// "latest" this is the newest enabled version: a version discarded by a
+// conflicting update is newer than the current one, but destroyed
+func (s *SecretManagerBackend) currentVersion(ctx context.Context) (*secretmanagerpb.SecretVersion, error) {
+	version, err := s.client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: s.GetName(true)})
+	if err != nil || !strings.HasSuffix(s.GetName(true), "/latest") {
+		return version, err
+	}
+
+	for version.State != secretmanagerpb.SecretVersion_ENABLED {
+		number := versionNumber(version.Name)
+		if number <= 1 {
+			return nil, status.Errorf(codes.NotFound, "secret %s has no enabled version", s.GetName(false))
+		}
+
+		version, err = s.client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{
+			Name: fmt.Sprintf("%s/versions/%d", s.GetName(false), number-1),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return version, nil
+}
+
+func (s *SecretManagerBackend) GetSecret(ctx context.Context) (*Secret, error) {
+	current, err := s.currentVersion(ctx)
+	if status.Code(err) == codes.NotFound {
+		logging.FromContext(ctx).Infof("Secret %s not found: %v", s.GetName(false), err)
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret: %v", err)
+	}
+
 	accessRequest := &secretmanagerpb.AccessSecretVersionRequest{
-		Name: s.GetName(true),
+		Name: current.Name,
 	}
 
 	// Call the API.
-	result, err := s.client.AccessSecretVersion(s.ctx, accessRequest)
+	result, err := s.client.AccessSecretVersion(ctx, accessRequest)
 	if err != nil {
-		log.Println(err)
-		return nil
+		return nil, fmt.Errorf("failed to access secret: %v", err)
 	}
 
 	var secret *Secret
@@ -56,35 +112,39 @@ func (s *SecretManagerBackend) GetSecret() *Secret {
 	err = json.Unmarshal(result.Payload.Data, &secret)
 
 	if err != nil {
-		log.Fatalf("Could not unmarshal secret data (%v)", result.Payload.Data)
+		return nil, fmt.Errorf("Could not unmarshal secret data: %v", err)
 	}
 
-	return secret
+	secret.Version = result.Name
+
+	return secret, nil
 }
 
-func (s *SecretManagerBackend) CreateSecret(payload *Secret) *Secret {
+func (s *SecretManagerBackend) CreateSecret(ctx context.Context, payload *Secret) (*Secret, error) {
 
 	createSecretReq := &secretmanagerpb.CreateSecretRequest{
 		Parent:   fmt.Sprintf("projects/%s", s.config.ProjectId),
 		SecretId: s.config.SecretId,
 		Secret: &secretmanagerpb.Secret{
-			Replication: &secretmanagerpb.Replication{
-				Replication: &secretmanagerpb.Replication_Automatic_{
-					Automatic: &secretmanagerpb.Replication_Automatic{},
-				},
-			},
+			Replication: automaticReplication(),
 		},
 	}
 
-	secret, err := s.client.CreateSecret(s.ctx, createSecretReq)
+	secret, err := s.client.CreateSecret(ctx, createSecretReq)
+
+	// the secret exists without versions when it was created to hold a lock
+	if status.Code(err) == codes.AlreadyExists {
+		secret, err = s.client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{Name: s.GetName(false)})
+	}
+
 	if err != nil {
-		log.Fatalf("failed to create secret: %v", err)
+		return nil, fmt.Errorf("failed to create secret: %v", err)
 	}
 
 	data, err := json.Marshal(payload)
 
 	if err != nil {
-		log.Fatalf("Error while trying to marshal payload: %v", payload)
+		return nil, fmt.Errorf("Error while trying to marshal payload: %v", err)
 	}
 
 	// Build the request.
@@ -95,31 +155,38 @@ func (s *SecretManagerBackend) CreateSecret(payload *Secret) *Secret {
 		},
 	}
 
-	_, err = s.client.AddSecretVersion(s.ctx, addSecretVersionReq)
+	version, err := s.client.AddSecretVersion(ctx, addSecretVersionReq)
 	if err != nil {
-		log.Fatalf("failed to add secret version: %v", err)
+		return nil, fmt.Errorf("failed to add secret version: %v", err)
 	}
 
-	return payload
+	payload.Version = version.Name
+
+	return payload, nil
 
 }
 
-func (s *SecretManagerBackend) UpdateSecret(payload *Secret) *Secret {
+// UpdateSecret adds the payload as new version and disables the version it
+// replaces. The check against the given version is best-effort only: another
+// writer can still add a version between the check and the add. Such a race
+// is detected afterwards, in which case the version added here is destroyed
+// again and ErrConflict is returned. Use a lock to exclude concurrent writers
+func (s *SecretManagerBackend) UpdateSecret(ctx context.Context, version string, payload *Secret) (*Secret, error) {
 
 	data, err := json.Marshal(payload)
 
 	if err != nil {
-		log.Fatalf("Error while trying to marshal payload: %v", payload)
+		return nil, fmt.Errorf("Error while trying to marshal payload: %v", err)
 	}
 
-	currentReq := &secretmanagerpb.GetSecretVersionRequest{
-		Name: s.GetName(true),
-	}
-
-	version, err := s.client.GetSecretVersion(s.ctx, currentReq)
+	current, err := s.currentVersion(ctx)
 
 	if err != nil {
-		log.Fatalf("failed to get secret version: %v", err)
+		return nil, fmt.Errorf("failed to get secret version: %v", err)
+	}
+
+	if current.Name != version {
+		return nil, fmt.Errorf("%w: read version %s, current version is %s", ErrConflict, version, current.Name)
 	}
 
 	req := &secretmanagerpb.AddSecretVersionRequest{
@@ -129,21 +196,172 @@ func (s *SecretManagerBackend) UpdateSecret(payload *Secret) *Secret {
 		},
 	}
 
-	// delete the old secret
-	_, err = s.client.AddSecretVersion(s.ctx, req)
+	added, err := s.client.AddSecretVersion(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to update secret: %v", err)
+	}
+
+	// version numbers are never reused, so there is a gap wherever an
+	// earlier update was discarded. Only a version that is still there
+	// means someone else added one after the check above
+	for number := versionNumber(current.Name) + 1; number < versionNumber(added.Name); number++ {
+		between, err := s.client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{
+			Name: fmt.Sprintf("%s/versions/%d", s.GetName(false), number),
+		})
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+
+		if err != nil {
+			s.discardVersion(ctx, added.Name)
+			return nil, fmt.Errorf("failed to get secret version: %v", err)
+		}
+
+		if between.State != secretmanagerpb.SecretVersion_DESTROYED {
+			s.discardVersion(ctx, added.Name)
+			return nil, fmt.Errorf("%w: version %s was added after %s", ErrConflict, between.Name, current.Name)
+		}
+	}
+
+	payload.Version = added.Name
+
+	// disable the old version, the etag makes this fail if it was changed
+	// by someone else in the meantime
+	disableReq := &secretmanagerpb.DisableSecretVersionRequest{
+		Name: current.Name,
+		Etag: current.Etag,
+	}
+
+	_, err = s.client.DisableSecretVersion(ctx, disableReq)
+	if code := status.Code(err); code == codes.Aborted || code == codes.FailedPrecondition {
+		s.discardVersion(ctx, added.Name)
+		return nil, fmt.Errorf("%w: version %s changed while updating", ErrConflict, current.Name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to disable secret version: %v", err)
+	}
+
+	return payload, nil
+}
+
+// discardVersion destroys a version added by a conflicting update, so it
+// does not shadow the current version
+func (s *SecretManagerBackend) discardVersion(ctx context.Context, name string) {
+	_, err := s.client.DestroySecretVersion(ctx, &secretmanagerpb.DestroySecretVersionRequest{Name: name})
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Could not destroy conflicting secret version %s: %v", name, err)
+	}
+}
+
+// versionNumber returns the number of a version resource name, or -1 if it
+// has none
+func versionNumber(name string) int {
+	number, err := strconv.Atoi(name[strings.LastIndex(name, "/")+1:])
 	if err != nil {
-		log.Fatalf("Failed to update secret: %v", err)
+		return -1
 	}
 
-	deleteReq := &secretmanagerpb.DisableSecretVersionRequest{
-		Name: version.Name,
+	return number
+}
+
+func (s *SecretManagerBackend) ListVersions(ctx context.Context) ([]Version, error) {
+	it := s.client.ListSecretVersions(ctx, &secretmanagerpb.ListSecretVersionsRequest{
+		Parent: s.GetName(false),
+	})
+
+	var versions []Version
+
+	for {
+		version, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secret versions: %v", err)
+		}
+
+		versions = append(versions, Version{
+			Name:      version.Name,
+			CreatedAt: version.CreateTime.AsTime(),
+			State:     strings.ToLower(version.State.String()),
+		})
 	}
 
-	if _, err := s.client.DisableSecretVersion(s.ctx, deleteReq); err != nil {
-		log.Fatalf("failed to disable secret version: %v", err)
+	return versions, nil
+}
+
+// Rollback re-enables the given version and stores its content as a new
+// version, so "latest" points at the rolled back certificate again. The
+// version may be given as number or as full resource name
+func (s *SecretManagerBackend) Rollback(ctx context.Context, version string) (secret *Secret, err error) {
+	if !strings.Contains(version, "/") {
+		version = fmt.Sprintf("%s/versions/%s", s.GetName(false), version)
 	}
 
-	return payload
+	target, err := s.client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: version})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret version: %v", err)
+	}
+
+	if target.State == secretmanagerpb.SecretVersion_DESTROYED {
+		return nil, fmt.Errorf("secret version %s has been destroyed", version)
+	}
+
+	if target.State == secretmanagerpb.SecretVersion_DISABLED {
+		_, err = s.client.EnableSecretVersion(ctx, &secretmanagerpb.EnableSecretVersionRequest{
+			Name: target.Name,
+			Etag: target.Etag,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to enable secret version: %v", err)
+		}
+	}
+
+	// only the current version stays enabled: after a rollback its content
+	// lives on in a new version, after a failure it goes back to disabled
+	disableTarget := target.State == secretmanagerpb.SecretVersion_DISABLED
+
+	defer func() {
+		if !disableTarget {
+			return
+		}
+
+		_, disableErr := s.client.DisableSecretVersion(ctx, &secretmanagerpb.DisableSecretVersionRequest{Name: target.Name})
+		if disableErr != nil {
+			logging.FromContext(ctx).Warnf("Could not disable secret version %s again: %v", target.Name, disableErr)
+		}
+	}()
+
+	result, err := s.client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: target.Name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to access secret version: %v", err)
+	}
+
+	if err := json.Unmarshal(result.Payload.Data, &secret); err != nil {
+		return nil, fmt.Errorf("Could not unmarshal secret data: %v", err)
+	}
+
+	current, err := s.currentVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret version: %v", err)
+	}
+
+	if current.Name == target.Name {
+		disableTarget = false
+		secret.Version = target.Name
+		return secret, nil
+	}
+
+	secret, err = s.UpdateSecret(ctx, current.Name, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	disableTarget = true
+
+	return secret, nil
 }
 
 func (s *SecretManagerBackend) Close() {
@@ -156,8 +374,8 @@ func (s *SecretManagerBackend) Name() string {
 func NewSecretManagerSecretBackend(ctx context.Context, config *SecretManagerConfig) SecretBackend {
 	client, err := secretmanager.NewClient(ctx)
 	if err != nil {
-		log.Fatalf("failed to setup client: %v", err)
+		logging.FromContext(ctx).Fatalf("failed to setup client: %v", err)
 	}
 
-	return &SecretManagerBackend{config, ctx, client}
+	return &SecretManagerBackend{config, client}
 }
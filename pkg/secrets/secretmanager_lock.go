@@ -0,0 +1,139 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/maxroll/auto-cert/pkg/lock"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	lockHolderLabel  = "autocert-lock-holder"
+	lockExpiresLabel = "autocert-lock-expires"
+)
+
+// SecretManagerLocker stores the lock in labels of the secret, using the
+// secret etag so only one holder can update them at a time
+type SecretManagerLocker struct {
+	backend *SecretManagerBackend
+	holder  string
+	ttl     time.Duration
+}
+
+func (s *SecretManagerBackend) NewLocker(holder string, ttl time.Duration) lock.Locker {
+	return &SecretManagerLocker{s, holder, ttl}
+}
+
+func (l *SecretManagerLocker) Name() string {
+	return "secretmanager"
+}
+
+func (l *SecretManagerLocker) TryLock(ctx context.Context) error {
+	expires := strconv.FormatInt(time.Now().Add(l.ttl).Unix(), 10)
+
+	secret, err := l.backend.client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{
+		Name: l.backend.GetName(false),
+	})
+
+	if status.Code(err) == codes.NotFound {
+		// create the secret without versions to hold the lock, the
+		// certificate is added as first version later
+		_, err = l.backend.client.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{
+			Parent:   fmt.Sprintf("projects/%s", l.backend.config.ProjectId),
+			SecretId: l.backend.config.SecretId,
+			Secret: &secretmanagerpb.Secret{
+				Replication: automaticReplication(),
+				Labels: map[string]string{
+					lockHolderLabel:  l.holder,
+					lockExpiresLabel: expires,
+				},
+			},
+		})
+
+		if status.Code(err) == codes.AlreadyExists {
+			return lock.ErrLocked
+		}
+
+		return err
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to get secret: %v", err)
+	}
+
+	if holder, ok := secret.Labels[lockHolderLabel]; ok && holder != l.holder {
+		expiresAt, _ := strconv.ParseInt(secret.Labels[lockExpiresLabel], 10, 64)
+
+		if time.Now().Unix() < expiresAt {
+			return lock.ErrLocked
+		}
+	}
+
+	labels := map[string]string{}
+	for k, v := range secret.Labels {
+		labels[k] = v
+	}
+	labels[lockHolderLabel] = l.holder
+	labels[lockExpiresLabel] = expires
+
+	return l.updateLabels(ctx, secret, labels)
+}
+
+func (l *SecretManagerLocker) Unlock(ctx context.Context) error {
+	secret, err := l.backend.client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{
+		Name: l.backend.GetName(false),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get secret: %v", err)
+	}
+
+	if holder := secret.Labels[lockHolderLabel]; holder != l.holder {
+		return fmt.Errorf("lock is held by %s", holder)
+	}
+
+	labels := map[string]string{}
+	for k, v := range secret.Labels {
+		if k != lockHolderLabel && k != lockExpiresLabel {
+			labels[k] = v
+		}
+	}
+
+	return l.updateLabels(ctx, secret, labels)
+}
+
+// updateLabels replaces the labels if the secret did not change since it was read
+func (l *SecretManagerLocker) updateLabels(ctx context.Context, secret *secretmanagerpb.Secret, labels map[string]string) error {
+	_, err := l.backend.client.UpdateSecret(ctx, &secretmanagerpb.UpdateSecretRequest{
+		Secret: &secretmanagerpb.Secret{
+			Name:   secret.Name,
+			Labels: labels,
+			Etag:   secret.Etag,
+		},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"labels"}},
+	})
+
+	// the etag no longer matches, someone else updated the labels first
+	if code := status.Code(err); code == codes.Aborted || code == codes.FailedPrecondition {
+		return lock.ErrLocked
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to update secret labels: %v", err)
+	}
+
+	return nil
+}
+
+func automaticReplication() *secretmanagerpb.Replication {
+	return &secretmanagerpb.Replication{
+		Replication: &secretmanagerpb.Replication_Automatic_{
+			Automatic: &secretmanagerpb.Replication_Automatic{},
+		},
+	}
+}
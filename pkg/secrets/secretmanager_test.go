@@ -0,0 +1,313 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/googleapis/gax-go/v2"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testSecretName = "projects/project/secrets/cert"
+
+// fakeClient keeps secret versions in memory, numbered from 1 like Secret
+// Manager does. Methods the backend does not use in these tests are left to
+// the nil embedded interface
+type fakeClient struct {
+	secretManagerClient
+
+	versions []*secretmanagerpb.SecretVersion
+	data     [][]byte
+	etag     int
+
+	// beforeAdd runs once before the next version is added
+	beforeAdd func()
+	// disableErr is returned once by the next disable
+	disableErr error
+}
+
+func (f *fakeClient) add(t *testing.T, secret *Secret) string {
+	t.Helper()
+
+	data, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.etag++
+	f.versions = append(f.versions, &secretmanagerpb.SecretVersion{
+		Name:  fmt.Sprintf("%s/versions/%d", testSecretName, len(f.versions)+1),
+		State: secretmanagerpb.SecretVersion_ENABLED,
+		Etag:  strconv.Itoa(f.etag),
+	})
+	f.data = append(f.data, data)
+
+	return f.versions[len(f.versions)-1].Name
+}
+
+func (f *fakeClient) lookup(name string) (int, error) {
+	if strings.HasSuffix(name, "/latest") && len(f.versions) > 0 {
+		return len(f.versions) - 1, nil
+	}
+
+	number, err := strconv.Atoi(name[strings.LastIndex(name, "/")+1:])
+	if err != nil || number < 1 || number > len(f.versions) {
+		return 0, status.Errorf(codes.NotFound, "version %s not found", name)
+	}
+
+	return number - 1, nil
+}
+
+func (f *fakeClient) setState(name string, etag string, state secretmanagerpb.SecretVersion_State) (*secretmanagerpb.SecretVersion, error) {
+	i, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if etag != "" && etag != f.versions[i].Etag {
+		return nil, status.Errorf(codes.Aborted, "etag mismatch")
+	}
+
+	f.etag++
+	f.versions[i].State = state
+	f.versions[i].Etag = strconv.Itoa(f.etag)
+
+	return f.version(i), nil
+}
+
+func (f *fakeClient) version(i int) *secretmanagerpb.SecretVersion {
+	return &secretmanagerpb.SecretVersion{Name: f.versions[i].Name, State: f.versions[i].State, Etag: f.versions[i].Etag}
+}
+
+func (f *fakeClient) state(t *testing.T, number int) secretmanagerpb.SecretVersion_State {
+	t.Helper()
+
+	if number > len(f.versions) {
+		t.Fatalf("version %d does not exist, have %d versions", number, len(f.versions))
+	}
+
+	return f.versions[number-1].State
+}
+
+func (f *fakeClient) GetSecretVersion(ctx context.Context, req *secretmanagerpb.GetSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	i, err := f.lookup(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.version(i), nil
+}
+
+func (f *fakeClient) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	i, err := f.lookup(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.versions[i].State != secretmanagerpb.SecretVersion_ENABLED {
+		return nil, status.Errorf(codes.FailedPrecondition, "version %s is %s", f.versions[i].Name, f.versions[i].State)
+	}
+
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name:    f.versions[i].Name,
+		Payload: &secretmanagerpb.SecretPayload{Data: f.data[i]},
+	}, nil
+}
+
+func (f *fakeClient) AddSecretVersion(ctx context.Context, req *secretmanagerpb.AddSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	if f.beforeAdd != nil {
+		beforeAdd := f.beforeAdd
+		f.beforeAdd = nil
+		beforeAdd()
+	}
+
+	f.etag++
+	f.versions = append(f.versions, &secretmanagerpb.SecretVersion{
+		Name:  fmt.Sprintf("%s/versions/%d", req.Parent, len(f.versions)+1),
+		State: secretmanagerpb.SecretVersion_ENABLED,
+		Etag:  strconv.Itoa(f.etag),
+	})
+	f.data = append(f.data, req.Payload.Data)
+
+	return f.version(len(f.versions) - 1), nil
+}
+
+func (f *fakeClient) EnableSecretVersion(ctx context.Context, req *secretmanagerpb.EnableSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	return f.setState(req.Name, req.Etag, secretmanagerpb.SecretVersion_ENABLED)
+}
+
+func (f *fakeClient) DisableSecretVersion(ctx context.Context, req *secretmanagerpb.DisableSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	if err := f.disableErr; err != nil {
+		f.disableErr = nil
+		return nil, err
+	}
+
+	return f.setState(req.Name, req.Etag, secretmanagerpb.SecretVersion_DISABLED)
+}
+
+func (f *fakeClient) DestroySecretVersion(ctx context.Context, req *secretmanagerpb.DestroySecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.SecretVersion, error) {
+	return f.setState(req.Name, req.Etag, secretmanagerpb.SecretVersion_DESTROYED)
+}
+
+func newFakeBackend() (*SecretManagerBackend, *fakeClient) {
+	client := &fakeClient{}
+
+	return &SecretManagerBackend{
+		config: &SecretManagerConfig{ProjectId: "project", SecretId: "cert", UseLatest: true},
+		client: client,
+	}, client
+}
+
+func getSecret(t *testing.T, backend *SecretManagerBackend) *Secret {
+	t.Helper()
+
+	secret, err := backend.GetSecret(context.Background())
+	if err != nil {
+		t.Fatalf("GetSecret: %v", err)
+	}
+
+	if secret == nil {
+		t.Fatalf("GetSecret returned no secret")
+	}
+
+	return secret
+}
+
+func TestUpdateSecretAfterFailedDisable(t *testing.T) {
+	ctx := context.Background()
+	backend, client := newFakeBackend()
+	v1 := client.add(t, &Secret{Certificate: "cert-1"})
+
+	client.disableErr = status.Error(codes.Aborted, "etag mismatch")
+
+	if _, err := backend.UpdateSecret(ctx, v1, &Secret{Certificate: "cert-2"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("UpdateSecret with failing disable = %v, want ErrConflict", err)
+	}
+
+	if state := client.state(t, 2); state != secretmanagerpb.SecretVersion_DESTROYED {
+		t.Errorf("conflicting version is %s, want destroyed", state)
+	}
+
+	// the discarded version leaves a gap, it must not block later updates
+	secret := getSecret(t, backend)
+	if secret.Certificate != "cert-1" || secret.Version != v1 {
+		t.Fatalf("GetSecret = %s from %s, want cert-1 from %s", secret.Certificate, secret.Version, v1)
+	}
+
+	updated, err := backend.UpdateSecret(ctx, secret.Version, &Secret{Certificate: "cert-3"})
+	if err != nil {
+		t.Fatalf("UpdateSecret after conflict: %v", err)
+	}
+
+	if updated.Version != testSecretName+"/versions/3" {
+		t.Errorf("updated version = %s, want version 3", updated.Version)
+	}
+
+	if state := client.state(t, 1); state != secretmanagerpb.SecretVersion_DISABLED {
+		t.Errorf("replaced version is %s, want disabled", state)
+	}
+
+	if secret := getSecret(t, backend); secret.Certificate != "cert-3" {
+		t.Errorf("GetSecret = %s, want cert-3", secret.Certificate)
+	}
+}
+
+func TestUpdateSecretConcurrentWriter(t *testing.T) {
+	ctx := context.Background()
+	backend, client := newFakeBackend()
+	v1 := client.add(t, &Secret{Certificate: "cert-1"})
+
+	// another writer adds its version between the check and the add
+	client.beforeAdd = func() {
+		client.add(t, &Secret{Certificate: "other"})
+	}
+
+	if _, err := backend.UpdateSecret(ctx, v1, &Secret{Certificate: "mine"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("UpdateSecret racing another writer = %v, want ErrConflict", err)
+	}
+
+	if state := client.state(t, 3); state != secretmanagerpb.SecretVersion_DESTROYED {
+		t.Errorf("conflicting version is %s, want destroyed", state)
+	}
+
+	secret := getSecret(t, backend)
+	if secret.Certificate != "other" {
+		t.Fatalf("GetSecret = %s, want the other writer's version", secret.Certificate)
+	}
+
+	if _, err := backend.UpdateSecret(ctx, secret.Version, &Secret{Certificate: "mine"}); err != nil {
+		t.Fatalf("UpdateSecret after conflict: %v", err)
+	}
+
+	if secret := getSecret(t, backend); secret.Certificate != "mine" {
+		t.Errorf("GetSecret = %s, want mine", secret.Certificate)
+	}
+}
+
+func TestUpdateSecretStaleVersion(t *testing.T) {
+	backend, client := newFakeBackend()
+	v1 := client.add(t, &Secret{Certificate: "cert-1"})
+	client.add(t, &Secret{Certificate: "cert-2"})
+
+	if _, err := backend.UpdateSecret(context.Background(), v1, &Secret{Certificate: "cert-3"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("UpdateSecret with stale version = %v, want ErrConflict", err)
+	}
+
+	if len(client.versions) != 2 {
+		t.Errorf("stale update added a version")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	backend, client := newFakeBackend()
+	client.add(t, &Secret{Certificate: "cert-1"})
+	client.add(t, &Secret{Certificate: "cert-2"})
+	client.versions[0].State = secretmanagerpb.SecretVersion_DISABLED
+
+	secret, err := backend.Rollback(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	if secret.Certificate != "cert-1" || secret.Version != testSecretName+"/versions/3" {
+		t.Errorf("Rollback = %s in %s, want cert-1 in version 3", secret.Certificate, secret.Version)
+	}
+
+	for number, want := range []secretmanagerpb.SecretVersion_State{
+		secretmanagerpb.SecretVersion_DISABLED,
+		secretmanagerpb.SecretVersion_DISABLED,
+		secretmanagerpb.SecretVersion_ENABLED,
+	} {
+		if state := client.state(t, number+1); state != want {
+			t.Errorf("version %d is %s, want %s", number+1, state, want)
+		}
+	}
+}
+
+func TestRollbackFailureDisablesTarget(t *testing.T) {
+	backend, client := newFakeBackend()
+	client.add(t, &Secret{Certificate: "cert-1"})
+	client.add(t, &Secret{Certificate: "cert-2"})
+	client.versions[0].State = secretmanagerpb.SecretVersion_DISABLED
+
+	client.disableErr = status.Error(codes.Aborted, "etag mismatch")
+
+	if _, err := backend.Rollback(context.Background(), "1"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Rollback with failing update = %v, want ErrConflict", err)
+	}
+
+	if state := client.state(t, 1); state != secretmanagerpb.SecretVersion_DISABLED {
+		t.Errorf("rollback target is %s after a failed rollback, want disabled", state)
+	}
+
+	if secret := getSecret(t, backend); secret.Certificate != "cert-2" {
+		t.Errorf("GetSecret = %s, want cert-2", secret.Certificate)
+	}
+}
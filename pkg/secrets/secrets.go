@@ -1,13 +1,36 @@
 package secrets
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrConflict is returned when the secret changed since it was read
+var ErrConflict = errors.New("secret was modified concurrently")
+
 type SecretBackend interface {
-	GetSecret() *Secret
-	CreateSecret(payload *Secret) *Secret
-	UpdateSecret(payload *Secret) *Secret
+	// GetSecret returns nil without an error if the secret does not exist
+	GetSecret(ctx context.Context) (*Secret, error)
+	CreateSecret(ctx context.Context, payload *Secret) (*Secret, error)
+	// UpdateSecret stores payload as new version of the secret, failing with
+	// ErrConflict unless version is still the current version
+	UpdateSecret(ctx context.Context, version string, payload *Secret) (*Secret, error)
+	// ListVersions returns the stored versions of the secret, newest first
+	ListVersions(ctx context.Context) ([]Version, error)
+	// Rollback makes the content of a previous version the current secret
+	Rollback(ctx context.Context, version string) (*Secret, error)
 	Close()
 	Name() string
 }
 
+// Version describes a stored version of the secret
+type Version struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	State     string    `json:"state"`
+}
+
 type User struct {
 	Email      string `json:"email"`
 	PrivateKey string `json:"private_key"`
@@ -18,4 +41,9 @@ type Secret struct {
 	Certificate string   `json:"certificate"`
 	User        User     `json:"user"`
 	Hostnames   []string `json:"hostnames"`
+	// ValidationReissuedAt is when the certificate was last reissued because
+	// the stored one failed validation
+	ValidationReissuedAt *time.Time `json:"validation_reissued_at,omitempty"`
+	// Version identifies the stored version the secret was read from
+	Version string `json:"-"`
 }
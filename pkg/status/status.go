@@ -0,0 +1,174 @@
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/maxroll/auto-cert/pkg/runner"
+	"github.com/maxroll/auto-cert/pkg/secrets"
+)
+
+// Attempt is the last renewal attempt for a certificate
+type Attempt struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// RunnerStatus is the last result of a runner for a certificate
+type RunnerStatus struct {
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type Certificate struct {
+	Secret        string                  `json:"secret"`
+	Hostnames     []string                `json:"hostnames"`
+	SANs          []string                `json:"sans"`
+	Issuer        string                  `json:"issuer"`
+	Serial        string                  `json:"serial"`
+	NotBefore     time.Time               `json:"not_before"`
+	NotAfter      time.Time               `json:"not_after"`
+	DaysRemaining int                     `json:"days_remaining"`
+	LastAttempt   *Attempt                `json:"last_attempt,omitempty"`
+	Runners       map[string]RunnerStatus `json:"runners,omitempty"`
+}
+
+type CertificateSummary struct {
+	Secret        string `json:"secret"`
+	DaysRemaining int    `json:"days_remaining"`
+	Healthy       bool   `json:"healthy"`
+}
+
+type Summary struct {
+	Healthy      bool                 `json:"healthy"`
+	Certificates []CertificateSummary `json:"certificates"`
+}
+
+// Tracker keeps the state of every managed certificate in memory
+type Tracker struct {
+	mu           sync.RWMutex
+	certificates map[string]*Certificate
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{certificates: map[string]*Certificate{}}
+}
+
+func (t *Tracker) get(name string) *Certificate {
+	cert, ok := t.certificates[name]
+
+	if !ok {
+		cert = &Certificate{Secret: name, Runners: map[string]RunnerStatus{}}
+		t.certificates[name] = cert
+	}
+
+	return cert
+}
+
+// SetSecret updates the certificate details from the stored secret
+func (t *Tracker) SetSecret(name string, secret *secrets.Secret) error {
+	if secret == nil {
+		return nil
+	}
+
+	certificates, err := certcrypto.ParsePEMBundle([]byte(secret.Certificate))
+	if err != nil {
+		return err
+	}
+
+	leaf := certificates[0]
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cert := t.get(name)
+	cert.Hostnames = secret.Hostnames
+	cert.SANs = leaf.DNSNames
+	cert.Issuer = leaf.Issuer.String()
+	cert.Serial = leaf.SerialNumber.Text(16)
+	cert.NotBefore = leaf.NotBefore
+	cert.NotAfter = leaf.NotAfter
+
+	return nil
+}
+
+// RecordAttempt stores the outcome of a renewal attempt and its runners
+func (t *Tracker) RecordAttempt(name string, action string, err error, results []runner.Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	cert := t.get(name)
+
+	cert.LastAttempt = &Attempt{Time: now, Action: action}
+	if err != nil {
+		cert.LastAttempt.Error = err.Error()
+	}
+
+	for _, result := range results {
+		cert.Runners[result.Runner] = RunnerStatus{
+			Time:    now,
+			Success: result.Success,
+			Error:   result.Error,
+		}
+	}
+}
+
+// Certificates returns a copy of all tracked certificates sorted by secret
+func (t *Tracker) Certificates() []Certificate {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	certificates := make([]Certificate, 0, len(t.certificates))
+
+	for _, cert := range t.certificates {
+		c := *cert
+		c.DaysRemaining = int(time.Until(c.NotAfter).Hours()) / 24
+
+		c.Runners = make(map[string]RunnerStatus, len(cert.Runners))
+		for name, status := range cert.Runners {
+			c.Runners[name] = status
+		}
+
+		certificates = append(certificates, c)
+	}
+
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].Secret < certificates[j].Secret
+	})
+
+	return certificates
+}
+
+// Summary reports a certificate as healthy when it is valid for longer than
+// renewBefore and its last renewal attempt and runners did not fail
+func (t *Tracker) Summary(renewBefore time.Duration) Summary {
+	summary := Summary{Healthy: true, Certificates: []CertificateSummary{}}
+
+	for _, cert := range t.Certificates() {
+		healthy := time.Until(cert.NotAfter) > renewBefore
+
+		if cert.LastAttempt != nil && cert.LastAttempt.Error != "" {
+			healthy = false
+		}
+
+		for _, status := range cert.Runners {
+			if !status.Success {
+				healthy = false
+			}
+		}
+
+		summary.Healthy = summary.Healthy && healthy
+		summary.Certificates = append(summary.Certificates, CertificateSummary{
+			Secret:        cert.Secret,
+			DaysRemaining: cert.DaysRemaining,
+			Healthy:       healthy,
+		})
+	}
+
+	return summary
+}
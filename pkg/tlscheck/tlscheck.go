@@ -0,0 +1,171 @@
+package tlscheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/maxroll/auto-cert/pkg/util"
+)
+
+// Fetch connects to addr, or hostname:443 if addr is empty, and returns the
+// leaf certificate served for the hostname. The chain is not verified, the
+// served certificate is compared with the stored one instead
+func Fetch(ctx context.Context, hostname string, addr string) (*x509.Certificate, error) {
+	if addr == "" {
+		addr = net.JoinHostPort(hostname, "443")
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 10 * time.Second},
+		Config: &tls.Config{
+			ServerName:         hostname,
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+	}
+	defer conn.Close()
+
+	certificates := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("%s did not present a certificate", addr)
+	}
+
+	return certificates[0], nil
+}
+
+// Compare describes how the served certificate differs from the expected
+// one, an empty string means they are the same certificate
+func Compare(served *x509.Certificate, expected *x509.Certificate) string {
+	if util.Fingerprint(served) == util.Fingerprint(expected) {
+		return ""
+	}
+
+	return fmt.Sprintf("serves serial %s expiring %s, expected serial %s expiring %s",
+		served.SerialNumber.Text(16), served.NotAfter.Format(time.RFC3339),
+		expected.SerialNumber.Text(16), expected.NotAfter.Format(time.RFC3339))
+}
+
+// Checkable reports whether a TLS handshake can be made for the hostname,
+// which is not the case for wildcard hostnames
+func Checkable(hostname string) bool {
+	return !strings.Contains(hostname, "*")
+}
+
+// Target is where the certificate of a hostname is fetched from
+type Target struct {
+	Hostname string `json:"hostname"`
+	// ServerName is sent as SNI, the hostname unless overridden
+	ServerName string `json:"server_name"`
+	// Address is host:port to connect to, empty connects to hostname:443
+	Address string `json:"address,omitempty"`
+}
+
+// ParseOverrides parses comma separated hostname=[sni@]address overrides, a
+// hostname of * applies to all hostnames without their own override. A
+// missing port defaults to 443
+func ParseOverrides(value string) (map[string]Target, error) {
+	overrides := map[string]Target{}
+
+	if strings.TrimSpace(value) == "" {
+		return overrides, nil
+	}
+
+	for _, override := range strings.Split(value, ",") {
+		hostname, target, ok := strings.Cut(strings.TrimSpace(override), "=")
+		if !ok || hostname == "" || target == "" {
+			return nil, fmt.Errorf("Invalid TLS check override %s, expected hostname=[sni@]address", override)
+		}
+
+		serverName, address, ok := strings.Cut(target, "@")
+		if !ok {
+			serverName, address = "", target
+		}
+
+		if address == "" {
+			return nil, fmt.Errorf("Missing address in TLS check override %s", override)
+		}
+
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "443")
+		}
+
+		overrides[hostname] = Target{Hostname: hostname, ServerName: serverName, Address: address}
+	}
+
+	return overrides, nil
+}
+
+// TargetFor returns the target of the hostname with its override applied
+func TargetFor(hostname string, overrides map[string]Target) Target {
+	target := Target{Hostname: hostname, ServerName: hostname}
+
+	override, ok := overrides[hostname]
+	if !ok {
+		override, ok = overrides["*"]
+	}
+
+	if ok {
+		target.Address = override.Address
+		if override.ServerName != "" {
+			target.ServerName = override.ServerName
+		}
+	}
+
+	return target
+}
+
+// Check is the outcome of waiting for a hostname to serve a certificate
+type Check struct {
+	Target
+	Success  bool   `json:"success"`
+	Serial   string `json:"serial,omitempty"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// WaitForSerial fetches the certificate of the target until its serial
+// matches the expected certificate or the propagation window has passed
+func WaitForSerial(ctx context.Context, target Target, expected *x509.Certificate, window time.Duration, interval time.Duration) Check {
+	check := Check{Target: target}
+	deadline := time.Now().Add(window)
+
+	for {
+		check.Attempts++
+
+		served, err := Fetch(ctx, target.ServerName, target.Address)
+
+		if err == nil {
+			check.Serial = served.SerialNumber.Text(16)
+
+			if served.SerialNumber.Cmp(expected.SerialNumber) == 0 {
+				check.Success = true
+				check.Error = ""
+				return check
+			}
+
+			check.Error = fmt.Sprintf("serves serial %s, expected %s", check.Serial, expected.SerialNumber.Text(16))
+		} else {
+			check.Error = err.Error()
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return check
+		}
+
+		select {
+		case <-ctx.Done():
+			check.Error = fmt.Sprintf("%s, giving up: %v", check.Error, ctx.Err())
+			return check
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package tlscheck
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]Target
+		wantErr bool
+	}{
+		{"empty", "", map[string]Target{}, false},
+		{"blank", "  ", map[string]Target{}, false},
+		{
+			"default port",
+			"www.example.com=203.0.113.10",
+			map[string]Target{"www.example.com": {Hostname: "www.example.com", Address: "203.0.113.10:443"}},
+			false,
+		},
+		{
+			"server name and port",
+			"www.example.com=edge.example.net@203.0.113.10:8443",
+			map[string]Target{"www.example.com": {Hostname: "www.example.com", ServerName: "edge.example.net", Address: "203.0.113.10:8443"}},
+			false,
+		},
+		{
+			"wildcard",
+			"a.com=203.0.113.10, *=198.51.100.7",
+			map[string]Target{
+				"a.com": {Hostname: "a.com", Address: "203.0.113.10:443"},
+				"*":     {Hostname: "*", Address: "198.51.100.7:443"},
+			},
+			false,
+		},
+		{"missing separator", "www.example.com", nil, true},
+		{"missing hostname", "=203.0.113.10", nil, true},
+		{"missing target", "www.example.com=", nil, true},
+		{"missing address", "www.example.com=edge.example.net@", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOverrides(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOverrides(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOverrides(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetFor(t *testing.T) {
+	overrides := map[string]Target{
+		"a.com": {Hostname: "a.com", Address: "203.0.113.10:443"},
+		"b.com": {Hostname: "b.com", ServerName: "edge.example.net", Address: "203.0.113.11:443"},
+		"*":     {Hostname: "*", ServerName: "cdn.example.net", Address: "198.51.100.7:443"},
+	}
+
+	tests := []struct {
+		name      string
+		hostname  string
+		overrides map[string]Target
+		want      Target
+	}{
+		{"no overrides", "a.com", nil, Target{Hostname: "a.com", ServerName: "a.com"}},
+		{"address only", "a.com", overrides, Target{Hostname: "a.com", ServerName: "a.com", Address: "203.0.113.10:443"}},
+		{"server name", "b.com", overrides, Target{Hostname: "b.com", ServerName: "edge.example.net", Address: "203.0.113.11:443"}},
+		{"wildcard", "c.com", overrides, Target{Hostname: "c.com", ServerName: "cdn.example.net", Address: "198.51.100.7:443"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TargetFor(tt.hostname, tt.overrides); got != tt.want {
+				t.Errorf("TargetFor(%q) = %+v, want %+v", tt.hostname, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package util
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"sort"
+	"strings"
 
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/maxroll/auto-cert/pkg/requestor"
@@ -20,15 +24,59 @@ func CertToPEM(cert *x509.Certificate) []byte {
 	return pemCert
 }
 
-func StringSlicesEqual(a, b []string) bool {
+// Fingerprint returns the hex encoded SHA-256 fingerprint of the certificate
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
 
-	// sort first
-	sort.Strings(a)
-	sort.Strings(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// FingerprintMatches reports whether fingerprint is the SHA-1 or SHA-256
+// fingerprint of the certificate, in any case and with or without colons.
+// Fingerprints of other lengths can not be compared and always match
+func FingerprintMatches(cert *x509.Certificate, fingerprint string) bool {
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	sha1Sum := sha1.Sum(cert.Raw)
+
+	if len(fingerprint) != sha1.Size*2 && len(fingerprint) != sha256.Size*2 {
+		return true
+	}
+
+	return fingerprint == Fingerprint(cert) || fingerprint == hex.EncodeToString(sha1Sum[:])
+}
+
+// NormalizeHostnames trims and lower cases hostnames, dropping empty entries
+// and duplicates while keeping the order
+func NormalizeHostnames(hostnames []string) []string {
+	var normalized []string
+	seen := make(map[string]bool, len(hostnames))
+
+	for _, hostname := range hostnames {
+		hostname = strings.ToLower(strings.TrimSpace(hostname))
+
+		if hostname == "" || seen[hostname] {
+			continue
+		}
+
+		seen[hostname] = true
+		normalized = append(normalized, hostname)
+	}
+
+	return normalized
+}
+
+func StringSlicesEqual(a, b []string) bool {
 
 	if len(a) != len(b) {
 		return false
 	}
+
+	// sort copies first, callers may still rely on the original order
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
 	for i, v := range a {
 		if v != b[i] {
 			return false
@@ -0,0 +1,29 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeHostnames(t *testing.T) {
+	tests := []struct {
+		name      string
+		hostnames []string
+		want      []string
+	}{
+		{"unchanged", []string{"a.com", "b.com"}, []string{"a.com", "b.com"}},
+		{"whitespace", []string{"a.com", " b.com "}, []string{"a.com", "b.com"}},
+		{"case", []string{"Example.com", "*.EXAMPLE.com"}, []string{"example.com", "*.example.com"}},
+		{"duplicates", []string{"a.com", "A.com", "a.com "}, []string{"a.com"}},
+		{"empty entries", []string{"a.com", "", " "}, []string{"a.com"}},
+		{"nothing left", []string{" "}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeHostnames(tt.hostnames); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NormalizeHostnames(%q) = %q, want %q", tt.hostnames, got, tt.want)
+			}
+		})
+	}
+}